@@ -0,0 +1,98 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// boltBuckets is a bucketStore backed by a single BoltDB file.
+type boltBuckets struct {
+	db *bbolt.DB
+}
+
+// NewBoltStore opens (or creates) the BoltDB file at path and returns a Store
+// using it.
+func NewBoltStore(path string) (*KeyValueStore, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	log.Println("Opened BoltDB storage at", path)
+	return &KeyValueStore{kv: &boltBuckets{db: db}}, nil
+}
+
+func (bolt *boltBuckets) Get(bucket string, key string) ([]byte, error) {
+	var result []byte
+	err := bolt.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return ErrNotFound
+		}
+		value := b.Get([]byte(key))
+		if value == nil {
+			return ErrNotFound
+		}
+		result = append([]byte{}, value...)
+		return nil
+	})
+	return result, err
+}
+
+func (bolt *boltBuckets) Put(bucket string, key string, value []byte) error {
+	return bolt.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), value)
+	})
+}
+
+func (bolt *boltBuckets) Delete(bucket string, key string) error {
+	return bolt.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		return b.Delete([]byte(key))
+	})
+}
+
+func (bolt *boltBuckets) ForEach(bucket string, fn func(key string, value []byte) error) error {
+	return bolt.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(key []byte, value []byte) error {
+			return fn(string(key), value)
+		})
+	})
+}
+
+func (bolt *boltBuckets) Update(bucket string, key string, fn func(value []byte) ([]byte, error)) error {
+	return bolt.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+		var current []byte
+		if value := b.Get([]byte(key)); value != nil {
+			current = append([]byte{}, value...)
+		}
+		updated, err := fn(current)
+		if err != nil {
+			return err
+		}
+		if updated == nil {
+			return b.Delete([]byte(key))
+		}
+		return b.Put([]byte(key), updated)
+	})
+}
+
+func (bolt *boltBuckets) Close() error {
+	return bolt.db.Close()
+}
@@ -3,7 +3,6 @@ package main
 import (
 	"log"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
-	"github.com/multiplay/go-ts3"
 )
 
 type ChainLink interface {
@@ -19,7 +18,11 @@ type LogLink struct{}
 
 func (link LogLink) Run(context *BotContext, next func()) {
   update := context.update
-	log.Printf("[%s] %s", update.Message.From.UserName, update.Message.Text)
+  if update.Message != nil {
+    log.Printf("[%s] %s", update.Message.From.UserName, update.Message.Text)
+  } else if update.CallbackQuery != nil {
+    log.Printf("[%s] callback %s", update.CallbackQuery.From.UserName, update.CallbackQuery.Data)
+  }
 	next()
 }
 func (link LogLink) Name() string {
@@ -37,6 +40,10 @@ func NewCommandLink() CommandLink {
 }
 func (link CommandLink) Run(context *BotContext, next func()) {
   update := context.update
+  if update.Message == nil {
+    next()
+    return
+  }
 	text := update.Message.Text
   telegram := context.telegram
   respond := func(text string) {
@@ -55,14 +62,14 @@ func (link CommandLink) Run(context *BotContext, next func()) {
   cmd, args := parseCommand(command)
 
 	if handler, ok := link.commands[cmd]; ok {
-		if handler.IsAdmin() && !context.IsAdmin() {
-      respond("You are not allowed to use this command")
+		if !context.CanUse(handler) {
+      if context.Role() == role_guest {
+        respond("You are not allowed to use this command. Use /request_access to ask the admins for access")
+      } else {
+        respond("You are not allowed to use this command")
+      }
 			return
 		}
-		if handler.IsRestricted() && !context.IsOnWhitelist() {
-      respond("You are not allowed to use this command")
-      return
-		}
 		handler.Run(args, respond, context)
 	} else {
 		msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Unknown command")
@@ -79,27 +86,44 @@ func (link CommandLink) AddCommand(handler CommandHandler) {
 
 type BotContext struct {
 	telegram  *tgbotapi.BotAPI
-	teamspeak *ts3.Client
+	teamspeak TeamspeakClient
   update *tgbotapi.Update
   config *Config
-  repository *Repository
+  repository Store
+  relay *Relay
+  linker *Linker
+  // role caches Role for the current update.
+  role *Role
 }
 
+// IsSuperAdmin reports whether the sender is one of the admins in the config,
+// who cannot be removed at runtime.
+func (context BotContext) IsSuperAdmin() bool {
+  return is_config_admin(context.config, context.GetUserID())
+}
+
+// IsAdmin reports whether the sender is a config admin or was added with
+// /admin add.
 func (context BotContext) IsAdmin() bool {
-  id := context.update.SentFrom().ID
-  for _, admin_id := range context.config.Bot.AdminIds {
-    if admin_id == id {
+  if context.IsSuperAdmin() {
+    return true
+  }
+  is_admin, err := context.repository.IsAdmin(context.GetUserID())
+  if err != nil {
+    log.Println(err)
+  }
+  return is_admin
+}
+
+func is_config_admin(config *Config, telegram_id int64) bool {
+  for _, admin_id := range config.Bot.AdminIds {
+    if admin_id == telegram_id {
       return true
     }
   }
   return false
 }
 
-func (context BotContext) IsOnWhitelist() bool {
-  is_on_whitelist, err := context.repository.IsOnWhitelist(context.update.SentFrom().ID)
-  return (err == nil && is_on_whitelist) || context.IsAdmin()
-}
-
 func (context BotContext) GetUserID() int64 {
   return context.update.SentFrom().ID
 }
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// CallbackHandler handles presses of inline keyboard buttons. Button data is
+// "<prefix>:<data>", and the handler registered for the prefix receives the
+// part after the colon. Telegram limits button data to 64 bytes.
+type CallbackHandler interface {
+	Prefix() string
+	// Command is the command whose permissions the buttons share.
+	Command() string
+	// Run handles a button press. answer shows a short notification to the
+	// user; if it is not called the press is acknowledged silently.
+	Run(data string, answer func(string), context *BotContext)
+}
+
+func RegisterCallbacks(link *CallbackLink) {
+	link.AddHandler(SubscribeCallback{})
+	link.AddHandler(ToggleSubscriptionCallback{})
+	link.AddHandler(QuotesPageCallback{})
+	link.AddHandler(DeleteQuoteCallback{})
+	link.AddHandler(AccessRequestCallback{})
+}
+
+// CallbackLink routes callback queries to their handler. Updates without a
+// callback query are passed on to the next link.
+type CallbackLink struct {
+	handlers map[string]CallbackHandler
+	commands *map[string]CommandHandler
+}
+
+func NewCallbackLink(commands *map[string]CommandHandler) CallbackLink {
+	return CallbackLink{
+		handlers: make(map[string]CallbackHandler),
+		commands: commands,
+	}
+}
+func (link CallbackLink) Run(context *BotContext, next func()) {
+	query := context.update.CallbackQuery
+	if query == nil {
+		next()
+		return
+	}
+	answered := false
+	answer := func(text string) {
+		answered = true
+		if _, err := context.telegram.Request(tgbotapi.NewCallback(query.ID, text)); err != nil {
+			log.Println(err)
+		}
+	}
+	prefix, data, _ := strings.Cut(query.Data, ":")
+	handler, ok := link.handlers[prefix]
+	if !ok {
+		answer("This button is no longer supported")
+		return
+	}
+	command, ok := (*link.commands)[handler.Command()]
+	if !ok || !context.CanUse(command) {
+		answer("You are not allowed to use this command")
+		return
+	}
+	handler.Run(data, answer, context)
+	if !answered {
+		answer("")
+	}
+}
+func (link CallbackLink) Name() string {
+	return "CallbackLink"
+}
+func (link CallbackLink) AddHandler(handler CallbackHandler) {
+	link.handlers[handler.Prefix()] = handler
+}
+
+func callback_data(prefix string, data ...string) string {
+	return prefix + ":" + strings.Join(data, ":")
+}
+
+// send_with_keyboard replies to the current message with an inline keyboard.
+func send_with_keyboard(context *BotContext, text string, keyboard tgbotapi.InlineKeyboardMarkup) {
+	message := context.update.Message
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	msg.ReplyToMessageID = message.MessageID
+	msg.ReplyMarkup = keyboard
+	if _, err := context.telegram.Send(msg); err != nil {
+		log.Println(err)
+	}
+}
+
+// edit_callback_message replaces the text and keyboard of the message the
+// pressed button belongs to. A nil keyboard removes it.
+func edit_callback_message(context *BotContext, text string, keyboard *tgbotapi.InlineKeyboardMarkup) {
+	message := context.update.CallbackQuery.Message
+	if message == nil {
+		return
+	}
+	var edit tgbotapi.EditMessageTextConfig
+	if keyboard != nil {
+		edit = tgbotapi.NewEditMessageTextAndMarkup(message.Chat.ID, message.MessageID, text, *keyboard)
+	} else {
+		edit = tgbotapi.NewEditMessageText(message.Chat.ID, message.MessageID, text)
+	}
+	if _, err := context.telegram.Send(edit); err != nil {
+		log.Println(err)
+	}
+}
+
+type SubscribeCallback struct{}
+
+func (handler SubscribeCallback) Prefix() string {
+	return "subscribe"
+}
+func (handler SubscribeCallback) Command() string {
+	return "subscribe"
+}
+func (handler SubscribeCallback) Run(ts_id string, answer func(string), context *BotContext) {
+	users, err := getAllTeamspeakUsers(context.teamspeak)
+	if err != nil {
+		log.Println(err)
+		answer("Error getting Teamspeak users")
+		return
+	}
+	for _, user := range users {
+		if user.TsId == ts_id {
+			text := subscribe_to(user, context)
+			answer(text)
+			edit_callback_message(context, text, nil)
+			return
+		}
+	}
+	answer("This Teamspeak user no longer exists")
+}
+
+const subscribed_mark = "🔔"
+const unsubscribed_mark = "🔕"
+
+// subscription_button toggles the subscription to a user; its label shows
+// whether the user is currently subscribed.
+func subscription_button(ts_id string, nickname string, subscribed bool) tgbotapi.InlineKeyboardButton {
+	mark := unsubscribed_mark
+	if subscribed {
+		mark = subscribed_mark
+	}
+	return tgbotapi.NewInlineKeyboardButtonData(mark+" "+nickname, callback_data("toggle", ts_id))
+}
+
+// subscription_keyboard has one toggle button per user.
+func subscription_keyboard(users []TeamspeakUser, subscribed map[string]bool) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, user := range users {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(subscription_button(user.TsId, user.Nickname, subscribed[user.TsId])))
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// subscribed_teamspeaks returns the Teamspeak ids the user is subscribed to.
+func subscribed_teamspeaks(telegram_id int64, repository Store) (map[string]bool, error) {
+	entries, err := repository.GetSubscribedTeamspeaks(telegram_id)
+	if err != nil {
+		return nil, err
+	}
+	subscribed := make(map[string]bool)
+	for _, entry := range entries {
+		subscribed[entry.Id] = true
+	}
+	return subscribed, nil
+}
+
+type ToggleSubscriptionCallback struct{}
+
+func (handler ToggleSubscriptionCallback) Prefix() string {
+	return "toggle"
+}
+func (handler ToggleSubscriptionCallback) Command() string {
+	return "subscribe"
+}
+func (handler ToggleSubscriptionCallback) Run(ts_id string, answer func(string), context *BotContext) {
+	query := context.update.CallbackQuery
+	telegram_id := context.GetUserID()
+	subscribed, err := subscribed_teamspeaks(telegram_id, context.repository)
+	if err != nil {
+		log.Println(err)
+		answer("An error occured")
+		return
+	}
+	// The nickname is taken from the button, which is built by
+	// subscription_button.
+	var nickname string
+	if query.Message != nil && query.Message.ReplyMarkup != nil {
+		for _, row := range query.Message.ReplyMarkup.InlineKeyboard {
+			for _, button := range row {
+				if button.CallbackData != nil && *button.CallbackData == query.Data {
+					_, nickname, _ = strings.Cut(button.Text, " ")
+				}
+			}
+		}
+	}
+	if nickname == "" {
+		answer("This button is no longer valid")
+		return
+	}
+	if subscribed[ts_id] {
+		err = context.repository.RemoveSubscriber(telegram_id, ts_id)
+	} else {
+		err = context.repository.AddSubscriber(telegram_id, ts_id, nickname)
+	}
+	if err != nil {
+		log.Println(err)
+		answer("An error occured")
+		return
+	}
+	subscribed[ts_id] = !subscribed[ts_id]
+	if subscribed[ts_id] {
+		answer("Subscribed to " + nickname)
+	} else {
+		answer("Unsubscribed from " + nickname)
+	}
+
+	if query.Message == nil || query.Message.ReplyMarkup == nil {
+		return
+	}
+	keyboard := *query.Message.ReplyMarkup
+	for _, row := range keyboard.InlineKeyboard {
+		for i, button := range row {
+			if button.CallbackData != nil && *button.CallbackData == query.Data {
+				row[i] = subscription_button(ts_id, nickname, subscribed[ts_id])
+			}
+		}
+	}
+	edit := tgbotapi.NewEditMessageReplyMarkup(query.Message.Chat.ID, query.Message.MessageID, keyboard)
+	if _, err := context.telegram.Send(edit); err != nil {
+		log.Println(err)
+	}
+}
+
+const quotes_page_size = 10
+
+// quotes_page renders one page of quotes with buttons to move between pages.
+func quotes_page(quotes []Quote, page int, show_uuids bool) (string, *tgbotapi.InlineKeyboardMarkup) {
+	pages := (len(quotes) + quotes_page_size - 1) / quotes_page_size
+	if page >= pages {
+		page = pages - 1
+	}
+	if page < 0 {
+		page = 0
+	}
+	var text strings.Builder
+	for _, quote := range quotes[page*quotes_page_size : min_int((page+1)*quotes_page_size, len(quotes))] {
+		if show_uuids {
+			text.WriteString(fmt.Sprintf("ID: %s - \"%s\" by %s\n", quote.UUID, quote.Content, quote.Author))
+		} else {
+			text.WriteString(fmt.Sprintf("\"%s\" by %s\n", quote.Content, quote.Author))
+		}
+	}
+	if pages <= 1 {
+		return text.String(), nil
+	}
+	text.WriteString(fmt.Sprintf("\nPage %d of %d", page+1, pages))
+	ids := "0"
+	if show_uuids {
+		ids = "1"
+	}
+	var row []tgbotapi.InlineKeyboardButton
+	if page > 0 {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("« Previous", callback_data("quotes", strconv.Itoa(page-1), ids)))
+	}
+	if page < pages-1 {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("Next »", callback_data("quotes", strconv.Itoa(page+1), ids)))
+	}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(row)
+	return text.String(), &keyboard
+}
+
+type QuotesPageCallback struct{}
+
+func (handler QuotesPageCallback) Prefix() string {
+	return "quotes"
+}
+func (handler QuotesPageCallback) Command() string {
+	return "listquotes"
+}
+func (handler QuotesPageCallback) Run(data string, answer func(string), context *BotContext) {
+	page_str, ids, _ := strings.Cut(data, ":")
+	page, err := strconv.Atoi(page_str)
+	if err != nil {
+		answer("Invalid page")
+		return
+	}
+	quotes, err := context.repository.GetAllQuotes()
+	if err != nil {
+		log.Println(err)
+		answer("Error retrieving quotes")
+		return
+	}
+	if len(quotes) == 0 {
+		edit_callback_message(context, "No quotes found", nil)
+		return
+	}
+	text, keyboard := quotes_page(quotes, page, ids == "1")
+	edit_callback_message(context, text, keyboard)
+}
+
+type DeleteQuoteCallback struct{}
+
+func (handler DeleteQuoteCallback) Prefix() string {
+	return "deletequote"
+}
+func (handler DeleteQuoteCallback) Command() string {
+	return "deletequote"
+}
+func (handler DeleteQuoteCallback) Run(uuid string, answer func(string), context *BotContext) {
+	if uuid == "cancel" {
+		edit_callback_message(context, "Quote was not deleted", nil)
+		return
+	}
+	if err := context.repository.DeleteQuote(uuid); err != nil {
+		log.Println(err)
+		answer("Error deleting quote")
+		return
+	}
+	text := "Quote deleted"
+	if err := updateTeamspeakQuotes(context.repository, context.teamspeak); err != nil {
+		log.Println(err)
+	} else {
+		text += ", updated Teamspeak quotes"
+	}
+	edit_callback_message(context, text, nil)
+}
+
+// AccessRequestCallback handles the buttons of the messages /request_access
+// sends to the admins.
+type AccessRequestCallback struct{}
+
+func (handler AccessRequestCallback) Prefix() string {
+	return "access"
+}
+func (handler AccessRequestCallback) Command() string {
+	return "whitelist"
+}
+func (handler AccessRequestCallback) Run(data string, answer func(string), context *BotContext) {
+	decision, id_str, _ := strings.Cut(data, ":")
+	telegram_id, err := strconv.ParseInt(id_str, 10, 64)
+	if err != nil || (decision != "approve" && decision != "deny") {
+		answer("Invalid access request")
+		return
+	}
+	text := ""
+	if message := context.update.CallbackQuery.Message; message != nil {
+		text = message.Text + "\n\n"
+	}
+	admin := telegram_display_name(context.update.SentFrom())
+	// Every admin got the request, only the first decision counts.
+	if err := context.repository.RemoveAccessRequest(telegram_id); err == ErrNotFound {
+		edit_callback_message(context, text+"Already decided", nil)
+		return
+	} else if err != nil {
+		log.Println(err)
+		answer("An error occured")
+		return
+	}
+	if decision == "deny" {
+		edit_callback_message(context, text+"Denied by "+admin, nil)
+		notify_user(context.telegram, telegram_id, "Your access request was denied")
+		return
+	}
+	is_on_whitelist, err := context.repository.IsOnWhitelist(telegram_id)
+	if err != nil {
+		log.Println(err)
+		answer("An error occured")
+		return
+	}
+	if is_on_whitelist {
+		edit_callback_message(context, text+"Already on the whitelist", nil)
+		return
+	}
+	if err := context.repository.AddWhiteListEntry(telegram_id, requester_alias(context.telegram, telegram_id)); err != nil {
+		log.Println(err)
+		answer("An error occured")
+		return
+	}
+	edit_callback_message(context, text+"Approved by "+admin, nil)
+	notify_user(context.telegram, telegram_id, "Your access request was approved. Type /help to see available commands")
+}
+
+// requester_alias returns the Telegram username of the user, falling back
+// to their name or ID.
+func requester_alias(telegram *tgbotapi.BotAPI, telegram_id int64) string {
+	chat, err := telegram.GetChat(tgbotapi.ChatInfoConfig{ChatConfig: tgbotapi.ChatConfig{ChatID: telegram_id}})
+	if err != nil {
+		log.Println("Error looking up requester:", err)
+		return strconv.FormatInt(telegram_id, 10)
+	}
+	if chat.UserName != "" {
+		return chat.UserName
+	}
+	if name := strings.TrimSpace(chat.FirstName + " " + chat.LastName); name != "" {
+		return name
+	}
+	return strconv.FormatInt(telegram_id, 10)
+}
+
+func notify_user(telegram *tgbotapi.BotAPI, telegram_id int64, text string) {
+	if _, err := telegram.Send(tgbotapi.NewMessage(telegram_id, text)); err != nil {
+		log.Println("Error notifying user:", err)
+	}
+}
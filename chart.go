@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"time"
+)
+
+const (
+	activity_sample_interval = 5 * time.Minute
+	default_chart_days       = 7
+	max_chart_days           = 90
+
+	chart_margin     = 40
+	chart_cell_size  = 24
+	chart_font_scale = 2
+)
+
+var (
+	chart_background = color.RGBA{255, 255, 255, 255}
+	chart_foreground = color.RGBA{40, 40, 40, 255}
+	chart_grid       = color.RGBA{225, 225, 225, 255}
+	chart_line       = color.RGBA{33, 113, 181, 255}
+)
+
+// chart_font is a 3x5 pixel font covering the characters used in chart
+// labels, so no font files or external packages are needed.
+var chart_font = map[rune][5]string{
+	'0': {"###", "#.#", "#.#", "#.#", "###"},
+	'1': {".#.", "##.", ".#.", ".#.", "###"},
+	'2': {"###", "..#", "###", "#..", "###"},
+	'3': {"###", "..#", "###", "..#", "###"},
+	'4': {"#.#", "#.#", "###", "..#", "..#"},
+	'5': {"###", "#..", "###", "..#", "###"},
+	'6': {"###", "#..", "###", "#.#", "###"},
+	'7': {"###", "..#", "..#", "..#", "..#"},
+	'8': {"###", "#.#", "###", "#.#", "###"},
+	'9': {"###", "#.#", "###", "..#", "###"},
+	'-': {"...", "...", "###", "...", "..."},
+	'.': {"...", "...", "...", "...", ".#."},
+	':': {"...", ".#.", "...", ".#.", "..."},
+	'A': {".#.", "#.#", "###", "#.#", "#.#"},
+	'D': {"##.", "#.#", "#.#", "#.#", "##."},
+	'E': {"###", "#..", "##.", "#..", "###"},
+	'F': {"###", "#..", "##.", "#..", "#.."},
+	'H': {"#.#", "#.#", "###", "#.#", "#.#"},
+	'M': {"#.#", "###", "###", "#.#", "#.#"},
+	'O': {"###", "#.#", "#.#", "#.#", "###"},
+	'R': {"##.", "#.#", "##.", "#.#", "#.#"},
+	'S': {"###", "#..", "###", "..#", "###"},
+	'T': {"###", ".#.", ".#.", ".#.", ".#."},
+	'U': {"#.#", "#.#", "#.#", "#.#", "###"},
+	'W': {"#.#", "#.#", "###", "###", "#.#"},
+	'X': {"#.#", "#.#", ".#.", "#.#", "#.#"},
+}
+
+// weekday_labels are ordered Monday first, matching heatmap_row.
+var weekday_labels = []string{"MO", "TU", "WE", "TH", "FR", "SA", "SU"}
+
+func draw_text(img draw.Image, x int, y int, text string, c color.Color) {
+	for _, r := range text {
+		glyph, ok := chart_font[r]
+		if ok {
+			for row, line := range glyph {
+				for column, pixel := range line {
+					if pixel == '#' {
+						fill_rect(img, x+column*chart_font_scale, y+row*chart_font_scale, chart_font_scale, chart_font_scale, c)
+					}
+				}
+			}
+		}
+		x += 4 * chart_font_scale
+	}
+}
+
+func text_width(text string) int {
+	return len(text)*4*chart_font_scale - chart_font_scale
+}
+
+func fill_rect(img draw.Image, x int, y int, width int, height int, c color.Color) {
+	draw.Draw(img, image.Rect(x, y, x+width, y+height), &image.Uniform{c}, image.Point{}, draw.Src)
+}
+
+// draw_line draws a straight line with Bresenham's algorithm.
+func draw_line(img draw.Image, x0 int, y0 int, x1 int, y1 int, c color.Color) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	err := dx + dy
+	for {
+		img.Set(x0, y0, c)
+		img.Set(x0, y0+1, c)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * err
+		if e2 >= dy {
+			err += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			err += dx
+			y0 += sy
+		}
+	}
+}
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
+
+func encode_png(img image.Image) ([]byte, error) {
+	var buffer bytes.Buffer
+	err := png.Encode(&buffer, img)
+	return buffer.Bytes(), err
+}
+
+// heatmap_row maps a weekday to its row, Monday being the first one.
+func heatmap_row(weekday time.Weekday) int {
+	return (int(weekday) + 6) % 7
+}
+
+// heat_color blends from white to the line color by value between 0 and 1.
+func heat_color(value float64) color.Color {
+	blend := func(from uint8, to uint8) uint8 {
+		return uint8(float64(from) + (float64(to)-float64(from))*value)
+	}
+	return color.RGBA{blend(247, chart_line.R), blend(251, chart_line.G), blend(255, chart_line.B), 255}
+}
+
+// render_activity_heatmap draws the average number of users online per hour
+// of the day (columns) and weekday (rows), in location.
+func render_activity_heatmap(samples []ActivitySample, location *time.Location) ([]byte, error) {
+	var sums, counts [7][24]float64
+	for _, sample := range samples {
+		local := sample.Time.In(location)
+		row, column := heatmap_row(local.Weekday()), local.Hour()
+		sums[row][column] += float64(sample.Count)
+		counts[row][column]++
+	}
+	var averages [7][24]float64
+	highest := 0.0
+	for row := range averages {
+		for column := range averages[row] {
+			if counts[row][column] > 0 {
+				averages[row][column] = sums[row][column] / counts[row][column]
+			}
+			if averages[row][column] > highest {
+				highest = averages[row][column]
+			}
+		}
+	}
+
+	width := chart_margin + 24*chart_cell_size + chart_margin/2
+	height := chart_margin + 7*chart_cell_size + chart_margin
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	fill_rect(img, 0, 0, width, height, chart_background)
+	for row := 0; row < 7; row++ {
+		y := chart_margin + row*chart_cell_size
+		draw_text(img, 8, y+chart_cell_size/2-5, weekday_labels[row], chart_foreground)
+		for column := 0; column < 24; column++ {
+			x := chart_margin + column*chart_cell_size
+			value := 0.0
+			if highest > 0 {
+				value = averages[row][column] / highest
+			}
+			fill_rect(img, x+1, y+1, chart_cell_size-2, chart_cell_size-2, heat_color(value))
+		}
+	}
+	for column := 0; column < 24; column += 3 {
+		label := fmt.Sprintf("%d", column)
+		x := chart_margin + column*chart_cell_size + (chart_cell_size-text_width(label))/2
+		draw_text(img, x, chart_margin-16, label, chart_foreground)
+	}
+	draw_text(img, chart_margin, height-chart_margin+12, fmt.Sprintf("MAX %.1f", highest), chart_foreground)
+	return encode_png(img)
+}
+
+// render_activity_line draws the number of users online over time, from
+// since until now, with a tick at every local midnight.
+func render_activity_line(samples []ActivitySample, since time.Time, now time.Time, location *time.Location) ([]byte, error) {
+	plot_width, plot_height := 720, 240
+	width := chart_margin + plot_width + chart_margin/2
+	height := chart_margin/2 + plot_height + chart_margin
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	fill_rect(img, 0, 0, width, height, chart_background)
+
+	highest := 1
+	for _, sample := range samples {
+		if sample.Count > highest {
+			highest = sample.Count
+		}
+	}
+	left, top := chart_margin, chart_margin/2
+	bottom := top + plot_height
+	span := now.Sub(since).Seconds()
+	x_of := func(t time.Time) int {
+		return left + int(t.Sub(since).Seconds()/span*float64(plot_width))
+	}
+	y_of := func(count int) int {
+		return bottom - count*plot_height/highest
+	}
+
+	for count := 0; count <= highest; count += (highest + 3) / 4 {
+		y := y_of(count)
+		fill_rect(img, left, y, plot_width, 1, chart_grid)
+		label := fmt.Sprintf("%d", count)
+		draw_text(img, left-8-text_width(label), y-5, label, chart_foreground)
+	}
+	local := since.In(location)
+	day := time.Date(local.Year(), local.Month(), local.Day()+1, 0, 0, 0, 0, location)
+	days := int(now.Sub(since).Hours()/24) + 1
+	step := (days + 9) / 10
+	for i := 0; day.Before(now); i, day = i+1, day.AddDate(0, 0, 1) {
+		x := x_of(day)
+		fill_rect(img, x, top, 1, plot_height, chart_grid)
+		if i%step == 0 {
+			label := day.Format("01-02")
+			draw_text(img, x-text_width(label)/2, bottom+10, label, chart_foreground)
+		}
+	}
+	fill_rect(img, left, bottom, plot_width, 1, chart_foreground)
+	fill_rect(img, left, top, 1, plot_height, chart_foreground)
+
+	for i := 1; i < len(samples); i++ {
+		previous, current := samples[i-1], samples[i]
+		// Leave a gap where the bot was not sampling.
+		if current.Time.Sub(previous.Time) > 3*activity_sample_interval {
+			continue
+		}
+		draw_line(img, x_of(previous.Time), y_of(previous.Count), x_of(current.Time), y_of(current.Count), chart_line)
+	}
+	return encode_png(img)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"testing"
+	"time"
+)
+
+func TestRenderActivityCharts(t *testing.T) {
+	now := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	since := now.Add(-24 * time.Hour)
+	var samples []ActivitySample
+	for at := since; at.Before(now); at = at.Add(activity_sample_interval) {
+		samples = append(samples, ActivitySample{Time: at, Count: at.Hour() % 4})
+	}
+	heatmap, err := render_activity_heatmap(samples, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, err := render_activity_line(samples, since, now, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string][]byte{"heatmap": heatmap, "line": line} {
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if bounds := img.Bounds(); bounds.Dx() < 200 || bounds.Dy() < 100 {
+			t.Errorf("%s: unexpected size %v", name, bounds)
+		}
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"fmt"
 	"log"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/google/uuid"
@@ -14,8 +16,9 @@ import (
 type CommandHandler interface {
 	Command() string
 	Description() string
-	IsAdmin() bool
-	IsRestricted() bool
+	// DefaultRole is the lowest role allowed to use the command unless a
+	// lower one is granted with /role grant.
+	DefaultRole() Role
 	Run(args []string, respond func(string), context *BotContext)
 }
 
@@ -34,6 +37,24 @@ func RegisterCommands(link *CommandLink) {
 	link.AddCommand(ListQuotesCommand{})
 	link.AddCommand(DeleteQuoteCommand{})
 	link.AddCommand(ExportQuotesCommand{})
+	link.AddCommand(RelayCommand{})
+	link.AddCommand(MovesCommand{})
+	link.AddCommand(TemplateCommand{})
+	link.AddCommand(QuietCommand{})
+	link.AddCommand(DndCommand{})
+	link.AddCommand(DigestCommand{})
+	link.AddCommand(GroupCommand{})
+	link.AddCommand(SeenCommand{})
+	link.AddCommand(HistoryCommand{})
+	link.AddCommand(StatsCommand{})
+	link.AddCommand(ChartCommand{})
+	link.AddCommand(LinkCommand{})
+	link.AddCommand(UnlinkCommand{})
+	link.AddCommand(RoleCommand{&link.commands})
+	link.AddCommand(AdminCommand{})
+	link.AddCommand(RequestAccessCommand{})
+	link.AddCommand(InviteCommand{})
+	link.AddCommand(StartCommand{})
 }
 
 type HelpCommand struct {
@@ -46,18 +67,15 @@ func (cmd HelpCommand) Command() string {
 func (cmd HelpCommand) Description() string {
 	return "Prints this help message"
 }
-func (cmd HelpCommand) IsAdmin() bool {
-	return false
-}
-func (cmd HelpCommand) IsRestricted() bool {
-	return false
+func (cmd HelpCommand) DefaultRole() Role {
+	return role_guest
 }
 func (cmd HelpCommand) Run(args []string, respond func(string), context *BotContext) {
 	var text string = "Available commands:\n"
+	role := context.Role()
+	granted := all_granted_roles(context.repository)
 	for _, handler := range *cmd.commands {
-		if handler.IsAdmin() && !context.IsAdmin() {
-			continue
-		} else if handler.IsRestricted() && !context.IsOnWhitelist() {
+		if role < required_role(handler, granted[handler.Command()]) {
 			continue
 		}
 		text += "/" + handler.Command() + " - " + handler.Description() + "\n"
@@ -71,18 +89,22 @@ func (cmd MeCommand) Command() string {
 	return "me"
 }
 func (cmd MeCommand) Description() string {
-	return "Prints your Telegram ID"
+	return "Prints your Telegram ID and linked Teamspeak identity"
 }
-func (cmd MeCommand) IsAdmin() bool {
-	return false
-}
-func (cmd MeCommand) IsRestricted() bool {
-	return false
+func (cmd MeCommand) DefaultRole() Role {
+	return role_guest
 }
 func (cmd MeCommand) Run(args []string, respond func(string), context *BotContext) {
 	var id int64 = context.update.SentFrom().ID
 	var id_str string = fmt.Sprintf("%d", id)
-	respond("Your Telegram ID is " + id_str)
+	text := "Your Telegram ID is " + id_str
+	identity, err := context.repository.GetLinkedIdentity(id)
+	if err == nil {
+		text += fmt.Sprintf("\nLinked Teamspeak identity: %s (%s)", identity.Nickname, identity.TsId)
+	} else if err != ErrNotFound {
+		log.Println(err)
+	}
+	respond(text)
 }
 
 type ListCommand struct{}
@@ -91,24 +113,28 @@ func (cmd ListCommand) Command() string {
 	return "list"
 }
 func (cmd ListCommand) Description() string {
-	return "List all online users. Can be modified with arguments: /list id, /list all, /list id all"
+	return "List all online users. Can be modified with arguments: /list id, /list all, /list id all. Admins can add 'query' to include ServerQuery clients"
 }
-func (cmd ListCommand) IsAdmin() bool {
-	return false
-}
-func (cmd ListCommand) IsRestricted() bool {
-	return true
+func (cmd ListCommand) DefaultRole() Role {
+	return role_member
 }
 func (cmd ListCommand) Run(args []string, respond func(string), context *BotContext) {
 	showIds := false
 	showAll := false
+	showQuery := false
 	for _, arg := range args {
 		if arg == "id" {
 			showIds = true
 		} else if arg == "all" {
 			showAll = true
+		} else if arg == "query" {
+			showQuery = true
 		}
 	}
+	if showQuery && context.Role() < role_owner {
+		respond("You are not allowed to list ServerQuery clients")
+		return
+	}
 	teamspeak := context.teamspeak
 	var users []TeamspeakUser
 	var err error
@@ -116,6 +142,9 @@ func (cmd ListCommand) Run(args []string, respond func(string), context *BotCont
 		users, err = getAllTeamspeakUsers(teamspeak)
 	} else {
 		users, err = getTeamspeakUsers(teamspeak)
+		if !showQuery {
+			users = withoutQueryClients(users)
+		}
 	}
 	if err != nil {
 		log.Println(err)
@@ -139,13 +168,40 @@ func (cmd ListCommand) Run(args []string, respond func(string), context *BotCont
 		text = "Online users:\n"
 	}
 	for _, user := range users {
+		var suffix string
+		if user.IsQuery {
+			suffix = " [query]"
+		}
 		if showIds {
-			text += " - " + user.Nickname + " (id: " + user.TsId + ")\n"
+			text += " - " + user.Nickname + " (id: " + user.TsId + ")" + suffix + "\n"
 		} else {
-			text += " - " + user.Nickname + "\n"
+			text += " - " + user.Nickname + suffix + "\n"
 		}
 	}
-	respond(text)
+	if showAll {
+		respond(text)
+		return
+	}
+	// Online users get a button each to toggle the subscription.
+	subscribed, err := subscribed_teamspeaks(context.GetUserID(), context.repository)
+	if err != nil {
+		log.Println(err)
+		respond(text)
+		return
+	}
+	var toggles []TeamspeakUser
+	seen := make(map[string]bool)
+	for _, user := range withoutQueryClients(users) {
+		if !seen[user.TsId] {
+			seen[user.TsId] = true
+			toggles = append(toggles, user)
+		}
+	}
+	if len(toggles) == 0 {
+		respond(text)
+		return
+	}
+	send_with_keyboard(context, text, subscription_keyboard(toggles, subscribed))
 }
 
 type WhitelistCommand struct{}
@@ -156,11 +212,8 @@ func (cmd WhitelistCommand) Command() string {
 func (cmd WhitelistCommand) Description() string {
 	return "Manage the whitelist. Usage: /whitelist add <id> <alias>, /whitelist remove <id>, /whitelist list"
 }
-func (cmd WhitelistCommand) IsAdmin() bool {
-	return true
-}
-func (cmd WhitelistCommand) IsRestricted() bool {
-	return false
+func (cmd WhitelistCommand) DefaultRole() Role {
+	return role_owner
 }
 func (cmd WhitelistCommand) Run(args []string, respond func(string), context *BotContext) {
 	if len(args) == 0 {
@@ -228,38 +281,55 @@ func (cmd SubscribeCommand) Command() string {
 	return "subscribe"
 }
 func (cmd SubscribeCommand) Description() string {
-	return "usage: /subscribe <Teamspeak id>"
-}
-func (cmd SubscribeCommand) IsAdmin() bool {
-	return false
+	return "usage: /subscribe <Teamspeak nickname or id>"
 }
-func (cmd SubscribeCommand) IsRestricted() bool {
-	return true
+func (cmd SubscribeCommand) DefaultRole() Role {
+	return role_member
 }
 func (cmd SubscribeCommand) Run(args []string, respond func(string), context *BotContext) {
-	if len(args) != 1 {
-		respond("usage: /subscribe <Teamspeak id>")
+	if len(args) == 0 {
+		respond("usage: /subscribe <Teamspeak nickname or id>")
 		return
 	}
-	var identifier string = args[0]
+	var identifier string = strings.Join(args, " ")
 	users, err := getAllTeamspeakUsers(context.teamspeak)
 	if err != nil {
 		log.Println(err)
 		respond("Error getting Teamspeak users")
 		return
 	}
-	for _, user := range users {
-		if user.TsId == identifier {
-			err := context.repository.AddSubscriber(context.update.SentFrom().ID, user.TsId, user.Nickname)
-			if err != nil {
-				log.Println(err)
-				respond("Error adding subscriber")
-				return
-			}
-			respond("Added subscriber")
-			return
+	matches := match_users(identifier, users)
+	if len(matches) == 0 {
+		respond("No Teamspeak user matches " + identifier)
+		return
+	}
+	if len(matches) == 1 {
+		respond(subscribe_to(matches[0], context))
+		return
+	}
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, user := range matches {
+		if i == max_match_choices {
+			break
 		}
+		label := fmt.Sprintf("%s (%s)", user.Nickname, user.TsId)
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(label, callback_data("subscribe", user.TsId))))
 	}
+	text := fmt.Sprintf("%d users match %s, pick one:", len(matches), identifier)
+	if len(matches) > max_match_choices {
+		text = fmt.Sprintf("%d users match %s, showing the best %d. Pick one or be more specific:", len(matches), identifier, max_match_choices)
+	}
+	send_with_keyboard(context, text, tgbotapi.NewInlineKeyboardMarkup(rows...))
+}
+
+// subscribe_to subscribes the sender to user and returns the reply.
+func subscribe_to(user TeamspeakUser, context *BotContext) string {
+	err := context.repository.AddSubscriber(context.GetUserID(), user.TsId, user.Nickname)
+	if err != nil {
+		log.Println(err)
+		return "Error adding subscriber"
+	}
+	return fmt.Sprintf("Subscribed to %s (%s)", user.Nickname, user.TsId)
 }
 
 type SubscribedCommand struct{}
@@ -270,11 +340,8 @@ func (cmd SubscribedCommand) Command() string {
 func (cmd SubscribedCommand) Description() string {
 	return "List all subscribed Teamspeak users"
 }
-func (cmd SubscribedCommand) IsAdmin() bool {
-	return false
-}
-func (cmd SubscribedCommand) IsRestricted() bool {
-	return true
+func (cmd SubscribedCommand) DefaultRole() Role {
+	return role_member
 }
 func (cmd SubscribedCommand) Run(args []string, respond func(string), context *BotContext) {
 	var text string = "Subscribed users:\n"
@@ -298,11 +365,8 @@ func (cmd UnsubscribeCommand) Command() string {
 func (cmd UnsubscribeCommand) Description() string {
 	return "usage: /unsubscribe <Teamspeak id>"
 }
-func (cmd UnsubscribeCommand) IsAdmin() bool {
-	return false
-}
-func (cmd UnsubscribeCommand) IsRestricted() bool {
-	return true
+func (cmd UnsubscribeCommand) DefaultRole() Role {
+	return role_member
 }
 func (cmd UnsubscribeCommand) Run(args []string, respond func(string), context *BotContext) {
 	if len(args) != 1 {
@@ -327,11 +391,8 @@ func (cmd AddQuoteCommand) Command() string {
 func (cmd AddQuoteCommand) Description() string {
 	return "Adds a new quote. Usage: /addquote <author> <context>"
 }
-func (cmd AddQuoteCommand) IsAdmin() bool {
-	return false
-}
-func (cmd AddQuoteCommand) IsRestricted() bool {
-	return true
+func (cmd AddQuoteCommand) DefaultRole() Role {
+	return role_member
 }
 func (cmd AddQuoteCommand) Run(args []string, respond func(string), context *BotContext) {
 	if len(args) != 2 {
@@ -366,11 +427,8 @@ func (cmd UpdateQuotesCommand) Command() string {
 func (cmd UpdateQuotesCommand) Description() string {
 	return "Updates the quotes on the Teamspeak server"
 }
-func (cmd UpdateQuotesCommand) IsAdmin() bool {
-	return true
-}
-func (cmd UpdateQuotesCommand) IsRestricted() bool {
-	return false
+func (cmd UpdateQuotesCommand) DefaultRole() Role {
+	return role_owner
 }
 func (cmd UpdateQuotesCommand) Run(args []string, respond func(string), context *BotContext) {
 	if len(args) != 0 {
@@ -393,11 +451,8 @@ func (cmd ListQuotesCommand) Command() string {
 func (cmd ListQuotesCommand) Description() string {
 	return "Lists all quotes. Add 'id' to also show UUIDs: /listquotes id"
 }
-func (cmd ListQuotesCommand) IsAdmin() bool {
-	return false
-}
-func (cmd ListQuotesCommand) IsRestricted() bool {
-	return true
+func (cmd ListQuotesCommand) DefaultRole() Role {
+	return role_member
 }
 func (cmd ListQuotesCommand) Run(args []string, respond func(string), context *BotContext) {
 	showUUIDs := len(args) > 0 && args[0] == "id"
@@ -411,26 +466,12 @@ func (cmd ListQuotesCommand) Run(args []string, respond func(string), context *B
 		respond("No quotes found")
 		return
 	}
-	var responseText strings.Builder
-	var ChunkSize int
-	var maxChunkSize int = 3750
-	for _, quote := range quotes {
-		var quoteText string
-		if showUUIDs {
-			quoteText += fmt.Sprintf("ID: %s - \"%s\" by %s\n", quote.UUID, quote.Content, quote.Author) // Changed from Context to Content
-		} else {
-			quoteText += fmt.Sprintf("\"%s\" by %s\n", quote.Content, quote.Author) // Changed from Context to Content
-		}
-
-		if ChunkSize+len(quoteText) > maxChunkSize {
-			respond(responseText.String())
-			responseText.Reset()
-			ChunkSize = 0
-		}
-		responseText.WriteString(quoteText)
-		ChunkSize += len(quoteText)
+	text, keyboard := quotes_page(quotes, 0, showUUIDs)
+	if keyboard == nil {
+		respond(text)
+		return
 	}
-	respond(responseText.String())
+	send_with_keyboard(context, text, *keyboard)
 }
 
 type DeleteQuoteCommand struct{}
@@ -441,11 +482,8 @@ func (cmd DeleteQuoteCommand) Command() string {
 func (cmd DeleteQuoteCommand) Description() string {
 	return "Deletes a quote by UUID. Usage: /deletequote <uuid>"
 }
-func (cmd DeleteQuoteCommand) IsAdmin() bool {
-	return true
-}
-func (cmd DeleteQuoteCommand) IsRestricted() bool {
-	return false
+func (cmd DeleteQuoteCommand) DefaultRole() Role {
+	return role_moderator
 }
 func (cmd DeleteQuoteCommand) Run(args []string, respond func(string), context *BotContext) {
 	if len(args) != 1 {
@@ -453,19 +491,24 @@ func (cmd DeleteQuoteCommand) Run(args []string, respond func(string), context *
 		return
 	}
 	uuid := args[0]
-	err := context.repository.DeleteQuote(uuid)
+	quotes, err := context.repository.GetAllQuotes()
 	if err != nil {
 		log.Println(err)
-		respond("Error deleting quote")
+		respond("Error retrieving quotes")
 		return
 	}
-	respond("Quote deleted")
-	err = updateTeamspeakQuotes(context.repository, context.teamspeak)
-	if err != nil {
-		log.Println(err)
-		return
+	for _, quote := range quotes {
+		if quote.UUID == uuid {
+			text := fmt.Sprintf("Delete \"%s\" by %s?", quote.Content, quote.Author)
+			keyboard := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("Delete", callback_data("deletequote", uuid)),
+				tgbotapi.NewInlineKeyboardButtonData("Cancel", callback_data("deletequote", "cancel")),
+			))
+			send_with_keyboard(context, text, keyboard)
+			return
+		}
 	}
-	respond("Updated Teamspeak quotes")
+	respond("Quote not found")
 }
 
 type SetQuoteChannelCommand struct{}
@@ -476,11 +519,8 @@ func (cmd SetQuoteChannelCommand) Command() string {
 func (cmd SetQuoteChannelCommand) Description() string {
 	return "Sets the channel where quotes are posted. Usage: /setquotechannel <channel name>"
 }
-func (cmd SetQuoteChannelCommand) IsAdmin() bool {
-	return true
-}
-func (cmd SetQuoteChannelCommand) IsRestricted() bool {
-	return false
+func (cmd SetQuoteChannelCommand) DefaultRole() Role {
+	return role_owner
 }
 func (cmd SetQuoteChannelCommand) Run(args []string, respond func(string), context *BotContext) {
 	if len(args) != 1 {
@@ -507,12 +547,8 @@ func (cmd ExportQuotesCommand) Description() string {
 	return "Exports all quotes to a text file and sends it."
 }
 
-func (cmd ExportQuotesCommand) IsAdmin() bool {
-	return false
-}
-
-func (cmd ExportQuotesCommand) IsRestricted() bool {
-	return true
+func (cmd ExportQuotesCommand) DefaultRole() Role {
+	return role_member
 }
 
 func (cmd ExportQuotesCommand) Run(args []string, respond func(string), context *BotContext) {
@@ -552,3 +588,1128 @@ func (cmd ExportQuotesCommand) Run(args []string, respond func(string), context
 
 	respond("Quotes exported and sent successfully")
 }
+
+type RelayCommand struct{}
+
+func (cmd RelayCommand) Command() string {
+	return "relay"
+}
+func (cmd RelayCommand) Description() string {
+	return "Relays chat between this Telegram chat and a Teamspeak channel. Usage: /relay set <channel name>, /relay off, /relay status"
+}
+func (cmd RelayCommand) DefaultRole() Role {
+	return role_owner
+}
+func (cmd RelayCommand) Run(args []string, respond func(string), context *BotContext) {
+	if len(args) == 0 {
+		respond("Usage: /relay set <channel name>, /relay off, /relay status")
+		return
+	}
+	var subcommand string = args[0]
+	if subcommand == "set" {
+		if len(args) != 2 {
+			respond("Usage: /relay set <channel name>")
+			return
+		}
+		channel_name := args[1]
+		if err := context.relay.Set(context.update.Message.Chat.ID, channel_name); err != nil {
+			log.Println(err)
+			respond("Error setting up relay: " + err.Error())
+			return
+		}
+		respond("Messages in this chat are now relayed to the Teamspeak channel " + channel_name)
+	} else if subcommand == "off" {
+		if err := context.relay.Disable(); err != nil {
+			log.Println(err)
+			respond("An error occured")
+			return
+		}
+		respond("Relay disabled")
+	} else if subcommand == "status" {
+		relay, active := context.relay.Current()
+		if !active {
+			respond("Relay is not active")
+			return
+		}
+		respond(fmt.Sprintf("Relaying Teamspeak channel %s to Telegram chat %d", relay.ChannelName, relay.TelegramChatId))
+	} else {
+		respond("Usage: /relay set <channel name>, /relay off, /relay status")
+	}
+}
+
+type MovesCommand struct{}
+
+func (cmd MovesCommand) Command() string {
+	return "moves"
+}
+func (cmd MovesCommand) Description() string {
+	return "Get notified when subscribed users switch channels. Usage: /moves on, /moves off"
+}
+func (cmd MovesCommand) DefaultRole() Role {
+	return role_member
+}
+func (cmd MovesCommand) Run(args []string, respond func(string), context *BotContext) {
+	if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+		respond("Usage: /moves on, /moves off")
+		return
+	}
+	settings, err := context.repository.GetUserSettings(context.GetUserID())
+	if err != nil {
+		log.Println(err)
+		respond("An error occured")
+		return
+	}
+	settings.NotifyMoves = args[0] == "on"
+	if err := context.repository.SetUserSettings(settings); err != nil {
+		log.Println(err)
+		respond("An error occured")
+		return
+	}
+	if settings.NotifyMoves {
+		respond("You will be notified when subscribed users switch channels")
+	} else {
+		respond("You will no longer be notified when subscribed users switch channels")
+	}
+}
+
+type TemplateCommand struct{}
+
+func (cmd TemplateCommand) Command() string {
+	return "template"
+}
+func (cmd TemplateCommand) Description() string {
+	return "Customize your notifications. Usage: /template show, /template set <connect|disconnect> \"<text>\", /template reset <connect|disconnect>"
+}
+func (cmd TemplateCommand) DefaultRole() Role {
+	return role_member
+}
+func (cmd TemplateCommand) Run(args []string, respond func(string), context *BotContext) {
+	usage := "Usage: /template show, /template set <connect|disconnect> \"<text>\", /template reset <connect|disconnect>"
+	if len(args) == 0 {
+		args = []string{"show"}
+	}
+	settings, err := context.repository.GetUserSettings(context.GetUserID())
+	if err != nil {
+		log.Println(err)
+		respond("An error occured")
+		return
+	}
+	var subcommand string = args[0]
+	if subcommand == "show" {
+		text := "Connect: " + subscriber_template(settings, template_connect) + "\n"
+		text += "Disconnect: " + subscriber_template(settings, template_disconnect) + "\n\n"
+		text += "Available placeholders:\n"
+		for _, placeholder := range template_placeholders {
+			text += "{" + placeholder.Name + "} - " + placeholder.Description + "\n"
+		}
+		respond(text)
+		return
+	}
+	if (subcommand != "set" && subcommand != "reset") || len(args) < 2 {
+		respond(usage)
+		return
+	}
+	kind := args[1]
+	if kind != template_connect && kind != template_disconnect {
+		respond("Template must be either connect or disconnect")
+		return
+	}
+	var template string
+	if subcommand == "set" {
+		if len(args) < 3 {
+			respond("Usage: /template set <connect|disconnect> \"<text>\"")
+			return
+		}
+		template = strings.Join(args[2:], " ")
+		if err := validate_template(template); err != nil {
+			respond("Invalid template: " + err.Error())
+			return
+		}
+	}
+	if kind == template_connect {
+		settings.ConnectTemplate = template
+	} else {
+		settings.DisconnectTemplate = template
+	}
+	if err := context.repository.SetUserSettings(settings); err != nil {
+		log.Println(err)
+		respond("An error occured")
+		return
+	}
+	respond("Template saved. Preview:\n" + preview_template(subscriber_template(settings, kind)))
+}
+
+type QuietCommand struct{}
+
+func (cmd QuietCommand) Command() string {
+	return "quiet"
+}
+func (cmd QuietCommand) Description() string {
+	return "Set quiet hours for notifications. Usage: /quiet 23:00-08:00 [timezone] [silent|mute], /quiet off"
+}
+func (cmd QuietCommand) DefaultRole() Role {
+	return role_member
+}
+func (cmd QuietCommand) Run(args []string, respond func(string), context *BotContext) {
+	usage := "Usage: /quiet 23:00-08:00 [timezone] [silent|mute], /quiet off"
+	settings, err := context.repository.GetUserSettings(context.GetUserID())
+	if err != nil {
+		log.Println(err)
+		respond("An error occured")
+		return
+	}
+	if len(args) == 0 {
+		respond(describe_quiet_hours(settings, time.Now()))
+		return
+	}
+	if len(args) > 3 {
+		respond(usage)
+		return
+	}
+	if args[0] == "off" {
+		settings.QuietStart = ""
+		settings.QuietEnd = ""
+		settings.QuietMode = ""
+	} else {
+		start, end, err := parse_quiet_range(args[0])
+		if err != nil {
+			respond(err.Error() + "\n" + usage)
+			return
+		}
+		mode := quiet_mode_silent
+		timezone := settings.Timezone
+		for _, arg := range args[1:] {
+			if arg == quiet_mode_silent || arg == quiet_mode_mute {
+				mode = arg
+				continue
+			}
+			if _, err := time.LoadLocation(arg); err != nil {
+				respond("Unknown timezone " + arg + ", use a name like Europe/Warsaw")
+				return
+			}
+			timezone = arg
+		}
+		settings.QuietStart = start
+		settings.QuietEnd = end
+		settings.QuietMode = mode
+		settings.Timezone = timezone
+	}
+	if err := context.repository.SetUserSettings(settings); err != nil {
+		log.Println(err)
+		respond("An error occured")
+		return
+	}
+	respond(describe_quiet_hours(settings, time.Now()))
+}
+
+type DndCommand struct{}
+
+func (cmd DndCommand) Command() string {
+	return "dnd"
+}
+func (cmd DndCommand) Description() string {
+	return "Mute all notifications for a while. Usage: /dnd 2h, /dnd 30m, /dnd off"
+}
+func (cmd DndCommand) DefaultRole() Role {
+	return role_member
+}
+func (cmd DndCommand) Run(args []string, respond func(string), context *BotContext) {
+	settings, err := context.repository.GetUserSettings(context.GetUserID())
+	if err != nil {
+		log.Println(err)
+		respond("An error occured")
+		return
+	}
+	if len(args) == 0 {
+		respond(describe_quiet_hours(settings, time.Now()))
+		return
+	}
+	if len(args) != 1 {
+		respond("Usage: /dnd 2h, /dnd 30m, /dnd off")
+		return
+	}
+	if args[0] == "off" {
+		settings.DndUntil = time.Time{}
+	} else {
+		duration, err := time.ParseDuration(args[0])
+		if err != nil || duration <= 0 || duration > max_dnd_duration {
+			respond("Invalid duration, use something like 2h or 45m (at most 168h)")
+			return
+		}
+		settings.DndUntil = time.Now().Add(duration).Truncate(time.Second)
+	}
+	if err := context.repository.SetUserSettings(settings); err != nil {
+		log.Println(err)
+		respond("An error occured")
+		return
+	}
+	if settings.DndUntil.IsZero() {
+		respond("Do not disturb is off")
+	} else {
+		respond("Do not disturb until " + settings.DndUntil.In(user_location(settings)).Format("2006-01-02 15:04 MST"))
+	}
+}
+
+type DigestCommand struct{}
+
+func (cmd DigestCommand) Command() string {
+	return "digest"
+}
+func (cmd DigestCommand) Description() string {
+	return "Get one summary instead of individual notifications. Usage: /digest hourly, /digest daily, /digest off"
+}
+func (cmd DigestCommand) DefaultRole() Role {
+	return role_member
+}
+func (cmd DigestCommand) Run(args []string, respond func(string), context *BotContext) {
+	settings, err := context.repository.GetUserSettings(context.GetUserID())
+	if err != nil {
+		log.Println(err)
+		respond("An error occured")
+		return
+	}
+	if len(args) == 0 {
+		if settings.DigestMode == "" {
+			respond("Digest mode is off, notifications are sent as they happen")
+		} else if settings.DigestMode == digest_hourly {
+			respond("You receive an hourly digest")
+		} else {
+			respond("You receive a daily digest")
+		}
+		return
+	}
+	if len(args) != 1 || (args[0] != digest_hourly && args[0] != digest_daily && args[0] != "off") {
+		respond("Usage: /digest hourly, /digest daily, /digest off")
+		return
+	}
+	if args[0] == "off" {
+		settings.DigestMode = ""
+	} else {
+		settings.DigestMode = args[0]
+	}
+	if err := context.repository.SetUserSettings(settings); err != nil {
+		log.Println(err)
+		respond("An error occured")
+		return
+	}
+	if settings.DigestMode == "" {
+		// Send what was collected so far rather than dropping it.
+		if err := deliver_digest(context.GetUserID(), settings, context.repository, context.telegram); err != nil {
+			log.Println(err)
+		}
+		respond("Digest mode is off, notifications are sent as they happen")
+	} else if settings.DigestMode == digest_hourly {
+		respond("You will receive an hourly digest instead of individual notifications")
+	} else {
+		respond("You will receive a daily digest at midnight instead of individual notifications")
+	}
+}
+
+type GroupCommand struct{}
+
+func (cmd GroupCommand) Command() string {
+	return "group"
+}
+func (cmd GroupCommand) Description() string {
+	return "Notifies this chat when people are on Teamspeak. Usage: /group bind [threshold], /group unbind, /group list"
+}
+func (cmd GroupCommand) DefaultRole() Role {
+	return role_owner
+}
+func (cmd GroupCommand) Run(args []string, respond func(string), context *BotContext) {
+	usage := "Usage: /group bind [threshold], /group unbind, /group list"
+	if len(args) == 0 {
+		respond(usage)
+		return
+	}
+	chat := context.update.Message.Chat
+	var subcommand string = args[0]
+	if subcommand == "bind" {
+		if len(args) > 2 {
+			respond("Usage: /group bind [threshold]")
+			return
+		}
+		threshold := 1
+		if len(args) == 2 {
+			value, err := strconv.Atoi(args[1])
+			if err != nil || value < 1 {
+				respond("Threshold must be a positive number")
+				return
+			}
+			threshold = value
+		}
+		title := chat.Title
+		if title == "" {
+			title = telegram_display_name(context.update.SentFrom())
+		}
+		binding := GroupBinding{ChatId: chat.ID, Title: title, Threshold: threshold}
+		if err := context.repository.SetGroupBinding(binding); err != nil {
+			log.Println(err)
+			respond("An error occured")
+			return
+		}
+		if threshold == 1 {
+			respond("This chat will be notified when someone is on Teamspeak and when it is empty again")
+		} else {
+			respond(fmt.Sprintf("This chat will be notified when at least %d people are on Teamspeak and when it is empty again", threshold))
+		}
+	} else if subcommand == "unbind" {
+		err := context.repository.RemoveGroupBinding(chat.ID)
+		if err == ErrNotFound {
+			respond("This chat is not bound")
+			return
+		} else if err != nil {
+			log.Println(err)
+			respond("An error occured")
+			return
+		}
+		respond("This chat will no longer be notified")
+	} else if subcommand == "list" {
+		bindings, err := context.repository.GetGroupBindings()
+		if err != nil {
+			log.Println(err)
+			respond("An error occured")
+			return
+		}
+		if len(bindings) == 0 {
+			respond("No chats are bound")
+			return
+		}
+		var text string = "Bound chats:\n"
+		for _, binding := range bindings {
+			text += fmt.Sprintf("%s (%d) - at least %d online\n", binding.Title, binding.ChatId, binding.Threshold)
+		}
+		respond(text)
+	} else {
+		respond(usage)
+	}
+}
+
+type SeenCommand struct{}
+
+func (cmd SeenCommand) Command() string {
+	return "seen"
+}
+func (cmd SeenCommand) Description() string {
+	return "Shows when a Teamspeak user was last online. Usage: /seen <name>"
+}
+func (cmd SeenCommand) DefaultRole() Role {
+	return role_member
+}
+func (cmd SeenCommand) Run(args []string, respond func(string), context *BotContext) {
+	if len(args) == 0 {
+		respond("Usage: /seen <name>")
+		return
+	}
+	users, err := getAllTeamspeakUsers(context.teamspeak)
+	if err != nil {
+		log.Println(err)
+		respond("Error getting Teamspeak users")
+		return
+	}
+	matches := find_users_by_name(strings.Join(args, " "), users)
+	if len(matches) == 0 {
+		respond("No Teamspeak user matches " + strings.Join(args, " "))
+		return
+	}
+	settings, err := context.repository.GetUserSettings(context.GetUserID())
+	if err != nil {
+		log.Println(err)
+	}
+	location := user_location(settings)
+	var text string
+	for _, user := range matches {
+		session, err := context.repository.GetLastSession(user.TsId)
+		if err == ErrNotFound {
+			text += fmt.Sprintf("%s (%s) was never seen by the bot\n", user.Nickname, user.TsId)
+			continue
+		} else if err != nil {
+			log.Println(err)
+			respond("An error occured")
+			return
+		}
+		if session.Open {
+			text += fmt.Sprintf("%s (%s) is online since %s\n", user.Nickname, user.TsId, session.Start.In(location).Format("2006-01-02 15:04"))
+		} else {
+			ago := format_duration(time.Since(session.End))
+			text += fmt.Sprintf("%s (%s) was last seen %s (%s ago)\n", user.Nickname, user.TsId, session.End.In(location).Format("2006-01-02 15:04"), ago)
+		}
+	}
+	respond(text)
+}
+
+type HistoryCommand struct{}
+
+func (cmd HistoryCommand) Command() string {
+	return "history"
+}
+func (cmd HistoryCommand) Description() string {
+	return "Shows the recent sessions of a Teamspeak user, yourself if linked. Usage: /history [Teamspeak id] [days]"
+}
+func (cmd HistoryCommand) DefaultRole() Role {
+	return role_member
+}
+func (cmd HistoryCommand) Run(args []string, respond func(string), context *BotContext) {
+	if len(args) == 0 {
+		identity, err := context.repository.GetLinkedIdentity(context.GetUserID())
+		if err != nil {
+			respond("Usage: /history <Teamspeak id> [days], or /link your Teamspeak identity first")
+			return
+		}
+		args = []string{identity.TsId}
+	}
+	if len(args) > 2 {
+		respond("Usage: /history [Teamspeak id] [days]")
+		return
+	}
+	ts_id := args[0]
+	days := default_history_days
+	if len(args) == 2 {
+		value, err := strconv.Atoi(args[1])
+		if err != nil || value < 1 {
+			respond("Days must be a positive number")
+			return
+		}
+		days = value
+	}
+	sessions, err := context.repository.GetSessions(ts_id, time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		log.Println(err)
+		respond("An error occured")
+		return
+	}
+	if len(sessions) == 0 {
+		respond(fmt.Sprintf("No sessions of %s in the last %d days", ts_id, days))
+		return
+	}
+	settings, err := context.repository.GetUserSettings(context.GetUserID())
+	if err != nil {
+		log.Println(err)
+	}
+	location := user_location(settings)
+	text := fmt.Sprintf("Sessions of %s in the last %d days:\n", sessions[0].Nickname, days)
+	for i, session := range sessions {
+		if i == max_history_sessions {
+			text += fmt.Sprintf("... and %d more\n", len(sessions)-i)
+			break
+		}
+		text += format_session(session, location) + "\n"
+	}
+	respond(text)
+}
+
+type StatsCommand struct{}
+
+func (cmd StatsCommand) Command() string {
+	return "stats"
+}
+func (cmd StatsCommand) Description() string {
+	return "Shows online time statistics. Usage: /stats [week|month|all] [csv]"
+}
+func (cmd StatsCommand) DefaultRole() Role {
+	return role_member
+}
+func (cmd StatsCommand) Run(args []string, respond func(string), context *BotContext) {
+	period := "week"
+	export := false
+	for _, arg := range args {
+		if arg == "csv" {
+			export = true
+		} else {
+			period = arg
+		}
+	}
+	since_day, err := stats_since(period, time.Now())
+	if err != nil {
+		respond("Usage: /stats [week|month|all] [csv]")
+		return
+	}
+	stats, err := collect_stats(since_day, context.repository)
+	if err != nil {
+		log.Println(err)
+		respond("An error occured")
+		return
+	}
+	settings, err := context.repository.GetUserSettings(context.GetUserID())
+	if err != nil {
+		log.Println(err)
+	}
+	title := "of all time"
+	if period == "week" {
+		title = "of the last 7 days"
+	} else if period == "month" {
+		title = "of the last 30 days"
+	}
+	respond(format_stats(stats, title, user_location(settings)))
+	if !export || len(stats.Counters) == 0 {
+		return
+	}
+
+	data, err := stats_csv(stats)
+	if err != nil {
+		log.Println(err)
+		respond("Error writing statistics")
+		return
+	}
+	file := tgbotapi.FileReader{Name: "stats-" + period + ".csv", Reader: bytes.NewReader(data)}
+	if _, err := context.telegram.Send(tgbotapi.NewDocument(context.update.Message.Chat.ID, file)); err != nil {
+		log.Println(err)
+		respond("Failed to send the statistics file")
+	}
+}
+
+type ChartCommand struct{}
+
+func (cmd ChartCommand) Command() string {
+	return "chart"
+}
+func (cmd ChartCommand) Description() string {
+	return "Draws activity charts of the last days. Usage: /chart activity [days]"
+}
+func (cmd ChartCommand) DefaultRole() Role {
+	return role_member
+}
+func (cmd ChartCommand) Run(args []string, respond func(string), context *BotContext) {
+	usage := fmt.Sprintf("Usage: /chart activity [days], at most %d days", max_chart_days)
+	if len(args) == 0 || len(args) > 2 || args[0] != "activity" {
+		respond(usage)
+		return
+	}
+	days := default_chart_days
+	if len(args) == 2 {
+		value, err := strconv.Atoi(args[1])
+		if err != nil || value < 1 || value > max_chart_days {
+			respond(usage)
+			return
+		}
+		days = value
+	}
+	now := time.Now()
+	since := now.AddDate(0, 0, -days)
+	samples, err := context.repository.GetActivitySamples(since)
+	if err != nil {
+		log.Println(err)
+		respond("An error occured")
+		return
+	}
+	if len(samples) == 0 {
+		respond("No activity was recorded in this period yet")
+		return
+	}
+	settings, err := context.repository.GetUserSettings(context.GetUserID())
+	if err != nil {
+		log.Println(err)
+	}
+	location := user_location(settings)
+
+	heatmap, err := render_activity_heatmap(samples, location)
+	if err != nil {
+		log.Println(err)
+		respond("Error drawing chart")
+		return
+	}
+	line, err := render_activity_line(samples, since, now, location)
+	if err != nil {
+		log.Println(err)
+		respond("Error drawing chart")
+		return
+	}
+	chat_id := context.update.Message.Chat.ID
+	photo := tgbotapi.NewPhoto(chat_id, tgbotapi.FileBytes{Name: "heatmap.png", Bytes: heatmap})
+	photo.Caption = fmt.Sprintf("Average users online by weekday and hour (%s), last %d days", location, days)
+	if _, err := context.telegram.Send(photo); err != nil {
+		log.Println(err)
+		respond("Failed to send the chart")
+		return
+	}
+	photo = tgbotapi.NewPhoto(chat_id, tgbotapi.FileBytes{Name: "activity.png", Bytes: line})
+	photo.Caption = fmt.Sprintf("Users online over the last %d days", days)
+	if _, err := context.telegram.Send(photo); err != nil {
+		log.Println(err)
+		respond("Failed to send the chart")
+	}
+}
+
+type LinkCommand struct{}
+
+func (cmd LinkCommand) Command() string {
+	return "link"
+}
+func (cmd LinkCommand) Description() string {
+	return "Links your Telegram account to your Teamspeak identity"
+}
+func (cmd LinkCommand) DefaultRole() Role {
+	return role_member
+}
+func (cmd LinkCommand) Run(args []string, respond func(string), context *BotContext) {
+	code, err := context.linker.NewCode(context.GetUserID())
+	if err != nil {
+		log.Println(err)
+		respond("An error occured")
+		return
+	}
+	respond(fmt.Sprintf("Send the code %s as a private message to the bot on Teamspeak within %d minutes.", code, int(link_code_ttl.Minutes())))
+}
+
+type UnlinkCommand struct{}
+
+func (cmd UnlinkCommand) Command() string {
+	return "unlink"
+}
+func (cmd UnlinkCommand) Description() string {
+	return "Removes the link to your Teamspeak identity"
+}
+func (cmd UnlinkCommand) DefaultRole() Role {
+	return role_member
+}
+func (cmd UnlinkCommand) Run(args []string, respond func(string), context *BotContext) {
+	err := context.repository.RemoveLinkedIdentity(context.GetUserID())
+	if err == ErrNotFound {
+		respond("Your account is not linked")
+		return
+	} else if err != nil {
+		log.Println(err)
+		respond("An error occured")
+		return
+	}
+	respond("Your Teamspeak identity is no longer linked")
+}
+
+type RoleCommand struct {
+	commands *map[string]CommandHandler
+}
+
+func (cmd RoleCommand) Command() string {
+	return "role"
+}
+func (cmd RoleCommand) Description() string {
+	return "Manages roles and command permissions. Usage: /role grant <command> <role>, /role revoke <command> <role>, /role assign <telegram id> <role>, /role unassign <telegram id>, /role list"
+}
+func (cmd RoleCommand) DefaultRole() Role {
+	return role_owner
+}
+func (cmd RoleCommand) Run(args []string, respond func(string), context *BotContext) {
+	usage := "Usage: /role grant <command> <role>, /role revoke <command> <role>, /role assign <telegram id> <role>, /role unassign <telegram id>, /role list"
+	if len(args) == 0 {
+		respond(usage)
+		return
+	}
+	var subcommand string = args[0]
+	if subcommand == "grant" || subcommand == "revoke" {
+		if len(args) != 3 {
+			respond("Usage: /role " + subcommand + " <command> <role>")
+			return
+		}
+		name := strings.TrimPrefix(args[1], "/")
+		handler, ok := (*cmd.commands)[name]
+		if !ok {
+			respond("Unknown command /" + name)
+			return
+		}
+		role, ok := parse_role(args[2])
+		if !ok {
+			respond("Unknown role " + args[2] + ", use one of: " + strings.Join(role_names, ", "))
+			return
+		}
+		if role >= handler.DefaultRole() {
+			respond(fmt.Sprintf("/%s is always allowed for %s and above", name, handler.DefaultRole()))
+			return
+		}
+		if subcommand == "grant" {
+			if err := context.repository.GrantPermission(name, role.String()); err != nil {
+				log.Println(err)
+				respond("An error occured")
+				return
+			}
+			respond(fmt.Sprintf("Granted /%s to %s", name, role))
+			return
+		}
+		err := context.repository.RevokePermission(name, role.String())
+		if err == ErrNotFound {
+			respond(fmt.Sprintf("/%s was not granted to %s", name, role))
+			return
+		} else if err != nil {
+			log.Println(err)
+			respond("An error occured")
+			return
+		}
+		respond(fmt.Sprintf("Revoked /%s from %s", name, role))
+	} else if subcommand == "assign" {
+		if len(args) != 3 {
+			respond("Usage: /role assign <telegram id> <role>")
+			return
+		}
+		telegram_id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			respond("Invalid Telegram ID")
+			return
+		}
+		role, ok := parse_role(args[2])
+		if !ok {
+			respond("Unknown role " + args[2] + ", use one of: " + strings.Join(role_names, ", "))
+			return
+		}
+		if role == role_owner {
+			respond("Owners are admins, use /admin add")
+			return
+		}
+		if err := context.repository.SetUserRole(telegram_id, role.String()); err != nil {
+			log.Println(err)
+			respond("An error occured")
+			return
+		}
+		respond(fmt.Sprintf("%d is now a %s", telegram_id, role))
+	} else if subcommand == "unassign" {
+		if len(args) != 2 {
+			respond("Usage: /role unassign <telegram id>")
+			return
+		}
+		telegram_id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			respond("Invalid Telegram ID")
+			return
+		}
+		err = context.repository.RemoveUserRole(telegram_id)
+		if err == ErrNotFound {
+			respond(fmt.Sprintf("%d has no role assigned", telegram_id))
+			return
+		} else if err != nil {
+			log.Println(err)
+			respond("An error occured")
+			return
+		}
+		respond(fmt.Sprintf("Removed the role of %d", telegram_id))
+	} else if subcommand == "list" {
+		roles, err := context.repository.GetUserRoles()
+		if err != nil {
+			log.Println(err)
+			respond("An error occured")
+			return
+		}
+		var text string = "Assigned roles:\n"
+		if len(roles) == 0 {
+			text += "none\n"
+		}
+		for _, role := range roles {
+			text += fmt.Sprintf("%d - %s\n", role.TelegramId, role.Role)
+		}
+		var names []string
+		for name := range *cmd.commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		granted := all_granted_roles(context.repository)
+		text += "\nCommand permissions:\n"
+		for _, name := range names {
+			handler := (*cmd.commands)[name]
+			required := required_role(handler, granted[name])
+			text += fmt.Sprintf("/%s - %s", name, required)
+			if required != handler.DefaultRole() {
+				text += fmt.Sprintf(" (default %s)", handler.DefaultRole())
+			}
+			text += "\n"
+		}
+		respond(text)
+	} else {
+		respond(usage)
+	}
+}
+
+type AdminCommand struct{}
+
+func (cmd AdminCommand) Command() string {
+	return "admin"
+}
+func (cmd AdminCommand) Description() string {
+	return "Manages admins besides those in the config. Usage: /admin add <telegram id> [alias], /admin remove <telegram id>, /admin list"
+}
+func (cmd AdminCommand) DefaultRole() Role {
+	return role_owner
+}
+func (cmd AdminCommand) Run(args []string, respond func(string), context *BotContext) {
+	usage := "Usage: /admin add <telegram id> [alias], /admin remove <telegram id>, /admin list"
+	if len(args) == 0 {
+		respond(usage)
+		return
+	}
+	var subcommand string = args[0]
+	if subcommand == "add" || subcommand == "remove" {
+		// Only config admins may change who is an admin.
+		if !context.IsSuperAdmin() {
+			respond("Only admins from the config can manage admins")
+			return
+		}
+		if subcommand == "add" && (len(args) < 2 || len(args) > 3) {
+			respond("Usage: /admin add <telegram id> [alias]")
+			return
+		}
+		if subcommand == "remove" && len(args) != 2 {
+			respond("Usage: /admin remove <telegram id>")
+			return
+		}
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			respond("Invalid Telegram ID")
+			return
+		}
+		if is_config_admin(context.config, id) {
+			respond(args[1] + " is an admin in the config")
+			return
+		}
+		if subcommand == "add" {
+			admin := AdminEntry{TelegramId: id, AddedBy: context.GetUserID(), AddedAt: time.Now()}
+			if len(args) == 3 {
+				admin.Alias = args[2]
+			}
+			if err := context.repository.AddAdmin(admin); err != nil {
+				log.Println(err)
+				respond("An error occured")
+				return
+			}
+			respond("Added " + args[1] + " as an admin")
+			return
+		}
+		err = context.repository.RemoveAdmin(id)
+		if err == ErrNotFound {
+			respond(args[1] + " is not an admin")
+			return
+		} else if err != nil {
+			log.Println(err)
+			respond("An error occured")
+			return
+		}
+		respond("Removed " + args[1] + " from the admins")
+	} else if subcommand == "list" {
+		admins, err := context.repository.GetAdmins()
+		if err != nil {
+			log.Println(err)
+			respond("An error occured")
+			return
+		}
+		var text string = "Admins from the config:\n"
+		for _, admin_id := range context.config.Bot.AdminIds {
+			text += fmt.Sprintf("%d\n", admin_id)
+		}
+		if len(admins) > 0 {
+			text += "\nAdded admins:\n"
+		}
+		for _, admin := range admins {
+			if admin.Alias != "" {
+				text += admin.Alias + " - "
+			}
+			text += fmt.Sprintf("%d, added by %d on %s\n", admin.TelegramId, admin.AddedBy, admin.AddedAt.Format("2006-01-02"))
+		}
+		respond(text)
+	} else {
+		respond(usage)
+	}
+}
+
+// access_request_retry is how long a request stays pending before the user
+// may ask again, in case no admin ever decides on it.
+const access_request_retry = 24 * time.Hour
+
+type RequestAccessCommand struct{}
+
+func (cmd RequestAccessCommand) Command() string {
+	return "request_access"
+}
+func (cmd RequestAccessCommand) Description() string {
+	return "Asks the admins to add you to the whitelist. Usage: /request_access [message]"
+}
+func (cmd RequestAccessCommand) DefaultRole() Role {
+	return role_guest
+}
+func (cmd RequestAccessCommand) Run(args []string, respond func(string), context *BotContext) {
+	if context.Role() >= role_member {
+		respond("You already have access")
+		return
+	}
+	user := context.update.SentFrom()
+	now := time.Now()
+	request := AccessRequest{TelegramId: user.ID, RequestedAt: now}
+	if err := context.repository.AddAccessRequest(request, now.Add(-access_request_retry)); err == ErrAccessRequestPending {
+		respond("Your request is still pending. You will be notified when the admins decide")
+		return
+	} else if err != nil {
+		log.Println(err)
+		respond("An error occured")
+		return
+	}
+	text := fmt.Sprintf("%s (id: %d", telegram_display_name(user), user.ID)
+	if user.UserName != "" {
+		text += ", @" + user.UserName
+	}
+	text += ") requests access"
+	if len(args) > 0 {
+		text += ":\n" + strings.Join(args, " ")
+	}
+	id := strconv.FormatInt(user.ID, 10)
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Approve", callback_data("access", "approve", id)),
+		tgbotapi.NewInlineKeyboardButtonData("Deny", callback_data("access", "deny", id)),
+	))
+	sent := false
+	for _, admin_id := range admin_ids(context.config, context.repository) {
+		msg := tgbotapi.NewMessage(admin_id, text)
+		msg.ReplyMarkup = keyboard
+		if _, err := context.telegram.Send(msg); err != nil {
+			log.Println("Error sending access request:", err)
+			continue
+		}
+		sent = true
+	}
+	if !sent {
+		// Nobody got it, let the user try again.
+		if err := context.repository.RemoveAccessRequest(user.ID); err != nil {
+			log.Println(err)
+		}
+		respond("An error occured")
+		return
+	}
+	respond("Your request was sent to the admins. You will be notified when they decide")
+}
+
+type InviteCommand struct{}
+
+func (cmd InviteCommand) Command() string {
+	return "invite"
+}
+func (cmd InviteCommand) Description() string {
+	return "Creates links that add whoever opens them to the whitelist. Usage: /invite create [uses] [ttl], /invite list, /invite revoke <token>"
+}
+func (cmd InviteCommand) DefaultRole() Role {
+	return role_owner
+}
+func (cmd InviteCommand) Run(args []string, respond func(string), context *BotContext) {
+	usage := "Usage: /invite create [uses] [ttl], /invite list, /invite revoke <token>"
+	if len(args) == 0 {
+		respond(usage)
+		return
+	}
+	now := time.Now()
+	var subcommand string = args[0]
+	if subcommand == "create" {
+		if len(args) > 3 {
+			respond("Usage: /invite create [uses] [ttl], e.g. /invite create 5 2d")
+			return
+		}
+		uses := default_invite_uses
+		if len(args) > 1 {
+			value, err := strconv.Atoi(args[1])
+			if err != nil || value < 1 {
+				respond("Uses must be a positive number")
+				return
+			}
+			uses = value
+		}
+		ttl := default_invite_ttl
+		if len(args) > 2 {
+			value, err := parse_ttl(args[2])
+			if err != nil {
+				respond("Invalid time to live, use e.g. 12h or 7d")
+				return
+			}
+			ttl = value
+		}
+		invite, err := new_invite(context.GetUserID(), uses, ttl, now)
+		if err == nil {
+			err = context.repository.AddInvite(invite)
+		}
+		if err != nil {
+			log.Println(err)
+			respond("An error occured")
+			return
+		}
+		respond(fmt.Sprintf("%s\nValid for %d use(s) until %s", invite_link(context.telegram.Self.UserName, invite), invite.MaxUses, invite.ExpiresAt.Format("2006-01-02 15:04")))
+	} else if subcommand == "list" {
+		invites, err := context.repository.GetInvites()
+		if err != nil {
+			log.Println(err)
+			respond("An error occured")
+			return
+		}
+		if len(invites) == 0 {
+			respond("No invites")
+			return
+		}
+		var text string = "Invites:\n"
+		for _, invite := range invites {
+			text += format_invite(invite, now) + "\n"
+		}
+		respond(text)
+	} else if subcommand == "revoke" {
+		if len(args) != 2 {
+			respond("Usage: /invite revoke <token>")
+			return
+		}
+		err := context.repository.RemoveInvite(args[1])
+		if err == ErrNotFound {
+			respond("Invite not found")
+			return
+		} else if err != nil {
+			log.Println(err)
+			respond("An error occured")
+			return
+		}
+		respond("Revoked invite " + args[1])
+	} else {
+		respond(usage)
+	}
+}
+
+// StartCommand is sent by Telegram when a user opens the bot, with the
+// payload of the deep link if they came through an invite.
+type StartCommand struct{}
+
+func (cmd StartCommand) Command() string {
+	return "start"
+}
+func (cmd StartCommand) Description() string {
+	return "Starts the bot. Opened through an invite link it adds you to the whitelist"
+}
+func (cmd StartCommand) DefaultRole() Role {
+	return role_guest
+}
+func (cmd StartCommand) Run(args []string, respond func(string), context *BotContext) {
+	if len(args) == 0 {
+		respond("Type /help to see available commands")
+		return
+	}
+	if context.Role() >= role_member {
+		respond("You already have access. Type /help to see available commands")
+		return
+	}
+	user := context.update.SentFrom()
+	alias := user.UserName
+	if alias == "" {
+		alias = telegram_display_name(user)
+	}
+	// Whitelist first, so that a failure does not cost the invite a use, and
+	// take the entry back if the invite cannot be used.
+	if err := context.repository.AddWhiteListEntry(user.ID, alias); err != nil {
+		log.Println(err)
+		respond("An error occured")
+		return
+	}
+	invite, err := context.repository.UseInvite(args[0], time.Now())
+	if err != nil {
+		if err := context.repository.RemoveWhiteListEntry(user.ID); err != nil {
+			log.Println(err)
+		}
+	}
+	if err == ErrNotFound {
+		respond("This invite link is not valid")
+		return
+	} else if err == ErrInviteUnusable {
+		respond("This invite link has expired or was used up")
+		return
+	} else if err != nil {
+		log.Println(err)
+		respond("An error occured")
+		return
+	}
+	log.Println("Whitelisted", user.ID, "with invite", invite.Token)
+	respond("Welcome! You now have access. Type /help to see available commands")
+	notify_user(context.telegram, invite.CreatedBy, fmt.Sprintf("%s joined with invite %s", alias, invite.Token))
+}
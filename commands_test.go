@@ -0,0 +1,515 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"bridge/ts3fake"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	admin_id  = 1
+	member_id = 2
+	guest_id  = 3
+)
+
+// commandHarness runs messages through the relay and command links, with
+// the in-memory store, a fake Teamspeak server and a fake Telegram API.
+type commandHarness struct {
+	repository *KeyValueStore
+	server     *ts3fake.Server
+	fake       *fakeTelegram
+	context    BotContext
+	chain      Chain
+}
+
+func newCommandHarness(t *testing.T) *commandHarness {
+	t.Helper()
+	server, teamspeak := newFakeTeamspeak(t)
+	telegram, fake := newFakeTelegram(t)
+	repository := NewMemoryStore()
+	repository.AddWhiteListEntry(member_id, "member")
+	config := &Config{}
+	config.Bot.AdminIds = []int64{admin_id}
+	command_link := NewCommandLink()
+	RegisterCommands(&command_link)
+	callback_link := NewCallbackLink(&command_link.commands)
+	RegisterCallbacks(&callback_link)
+	relay := NewRelay(repository, teamspeak, telegram)
+	return &commandHarness{
+		repository: repository,
+		server:     server,
+		fake:       fake,
+		context: BotContext{
+			telegram:   telegram,
+			teamspeak:  teamspeak,
+			config:     config,
+			repository: repository,
+			relay:      relay,
+			linker:     NewLinker(repository, teamspeak, telegram),
+		},
+		chain: Chain{links: []ChainLink{&RelayLink{relay: relay}, &command_link, &callback_link}},
+	}
+}
+
+// send handles a message from the user in their private chat.
+func (harness *commandHarness) send(user_id int64, text string) {
+	context := harness.context
+	context.update = &tgbotapi.Update{Message: &tgbotapi.Message{
+		MessageID: 1,
+		From:      &tgbotapi.User{ID: user_id, UserName: "user"},
+		Chat:      &tgbotapi.Chat{ID: user_id},
+		Text:      text,
+	}}
+	onMessage(context, &harness.chain)
+}
+
+// press handles a press of a button with data on a message of the bot.
+func (harness *commandHarness) press(user_id int64, data string) {
+	harness.press_on(user_id, nil, data)
+}
+
+// press_on handles a press of a button with data on a message of the bot
+// with the keyboard markup.
+func (harness *commandHarness) press_on(user_id int64, markup *tgbotapi.InlineKeyboardMarkup, data string) {
+	context := harness.context
+	context.update = &tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:      "1",
+		From:    &tgbotapi.User{ID: user_id, UserName: "user"},
+		Message: &tgbotapi.Message{MessageID: 2, Chat: &tgbotapi.Chat{ID: user_id}, ReplyMarkup: markup},
+		Data:    data,
+	}}
+	onMessage(context, &harness.chain)
+}
+
+func TestWhitelistCommand(t *testing.T) {
+	harness := newCommandHarness(t)
+	fake := harness.fake
+
+	harness.send(member_id, "/whitelist add 5 bob")
+	fake.expect_message(t, member_id, "You are not allowed to use this command")
+
+	harness.send(admin_id, "/whitelist add 5 bob")
+	fake.expect_message(t, admin_id, "Added bob to the whitelist")
+	if ok, _ := harness.repository.IsOnWhitelist(5); !ok {
+		t.Fatal("5 was not whitelisted")
+	}
+	harness.send(admin_id, "/whitelist add nope bob")
+	fake.expect_message(t, admin_id, "Invalid Telegram ID")
+
+	harness.send(admin_id, "/whitelist list")
+	fake.expect_message(t, admin_id, "Whitelisted users:\nmember - 2\nbob - 5\n")
+
+	harness.send(admin_id, "/whitelist remove 5")
+	fake.expect_message(t, admin_id, "Removed 5 from the whitelist")
+	if ok, _ := harness.repository.IsOnWhitelist(5); ok {
+		t.Fatal("5 is still whitelisted")
+	}
+}
+
+func TestListCommand(t *testing.T) {
+	harness := newCommandHarness(t)
+	fake := harness.fake
+	harness.server.ClientEnter(ts3fake.Client{DatabaseID: 10, Nickname: "Alice"})
+
+	harness.send(member_id, "/list")
+	fake.expect_message(t, member_id, "Online users:\n - Alice\n")
+	harness.send(member_id, "/list query")
+	fake.expect_message(t, member_id, "You are not allowed to list ServerQuery clients")
+	harness.send(admin_id, "/list query")
+	fake.expect_message(t, admin_id, "Online users:\n - serveradmin [query]\n - Alice\n")
+}
+
+func TestSubscriptionCommands(t *testing.T) {
+	harness := newCommandHarness(t)
+	fake := harness.fake
+	harness.repository.AddSubscriber(member_id, "10", "Alice")
+	harness.repository.AddSubscriber(member_id, "11", "Bob")
+	harness.repository.AddSubscriber(admin_id, "12", "Carol")
+
+	harness.send(guest_id, "/subscribed")
+	fake.expect_message(t, guest_id, "You are not allowed to use this command. Use /request_access to ask the admins for access")
+
+	harness.send(member_id, "/subscribed")
+	fake.expect_message(t, member_id, "Subscribed users:\nAlice - 10\nBob - 11\n")
+
+	harness.send(member_id, "/unsubscribe 10")
+	fake.expect_message(t, member_id, "Removed subscriber")
+	entries, _ := harness.repository.GetSubscribedTeamspeaks(member_id)
+	if len(entries) != 1 || entries[0].Id != "11" {
+		t.Fatalf("unexpected subscriptions %+v", entries)
+	}
+}
+
+func TestSubscribeCommand(t *testing.T) {
+	harness := newCommandHarness(t)
+	fake := harness.fake
+	harness.server.ClientEnter(ts3fake.Client{DatabaseID: 10, Nickname: "Alice"})
+	harness.server.ClientEnter(ts3fake.Client{DatabaseID: 11, Nickname: "Alfred"})
+
+	harness.send(guest_id, "/subscribe alice")
+	fake.expect_message(t, guest_id, "You are not allowed to use this command. Use /request_access to ask the admins for access")
+
+	harness.send(member_id, "/subscribe alice")
+	fake.expect_message(t, member_id, "Subscribed to Alice (10)")
+	subscribers, err := harness.repository.GetSubscribers("10")
+	if err != nil || subscribers.Name != "Alice" || len(subscribers.TelegramSubscribers) != 1 {
+		t.Fatalf("got %+v, %v", subscribers, err)
+	}
+	harness.send(member_id, "/subscribe nobody")
+	fake.expect_message(t, member_id, "No Teamspeak user matches nobody")
+
+	// Several matches are offered as buttons, pressing one subscribes.
+	harness.send(member_id, "/subscribe al")
+	request := fake.expect_message(t, member_id, "2 users match al, pick one:")
+	if !strings.Contains(request.ReplyMarkup, `"callback_data":"subscribe:11"`) {
+		t.Fatalf("missing button in %s", request.ReplyMarkup)
+	}
+	harness.press(member_id, "subscribe:11")
+	if request := fake.next(t); request.Method != "answerCallbackQuery" {
+		t.Fatalf("got %s, want answerCallbackQuery", request.Method)
+	}
+	fake.expect_message(t, member_id, "Subscribed to Alfred (11)")
+
+	// Buttons are restricted like the command.
+	harness.press(guest_id, "subscribe:10")
+	if request := fake.next(t); request.Method != "answerCallbackQuery" || request.Text != "You are not allowed to use this command" {
+		t.Fatalf("unexpected answer %+v", request)
+	}
+}
+
+func TestToggleSubscriptionCallback(t *testing.T) {
+	harness := newCommandHarness(t)
+	fake := harness.fake
+	keyboard := subscription_keyboard([]TeamspeakUser{{TsId: "10", Nickname: "Alice"}}, nil)
+
+	harness.press_on(member_id, &keyboard, "toggle:10")
+	if request := fake.next(t); request.Method != "answerCallbackQuery" || request.Text != "Subscribed to Alice" {
+		t.Fatalf("got %s: %q", request.Method, request.Text)
+	}
+	if request := fake.next(t); request.Method != "editMessageReplyMarkup" || !strings.Contains(request.ReplyMarkup, subscribed_mark+" Alice") {
+		t.Fatalf("got %s: %s", request.Method, request.ReplyMarkup)
+	}
+	entries, _ := harness.repository.GetSubscribedTeamspeaks(member_id)
+	if len(entries) != 1 || entries[0].Name != "Alice" {
+		t.Fatalf("unexpected subscriptions %+v", entries)
+	}
+
+	keyboard = subscription_keyboard([]TeamspeakUser{{TsId: "10", Nickname: "Alice"}}, map[string]bool{"10": true})
+	harness.press_on(member_id, &keyboard, "toggle:10")
+	if request := fake.next(t); request.Method != "answerCallbackQuery" || request.Text != "Unsubscribed from Alice" {
+		t.Fatalf("got %s: %q", request.Method, request.Text)
+	}
+	if request := fake.next(t); !strings.Contains(request.ReplyMarkup, unsubscribed_mark+" Alice") {
+		t.Fatalf("got %s: %s", request.Method, request.ReplyMarkup)
+	}
+
+	// Without the button the nickname is unknown, nothing is stored.
+	harness.press(member_id, "toggle:11")
+	if request := fake.next(t); request.Method != "answerCallbackQuery" || request.Text != "This button is no longer valid" {
+		t.Fatalf("got %s: %q", request.Method, request.Text)
+	}
+	if entries, _ := harness.repository.GetSubscribedTeamspeaks(member_id); len(entries) != 0 {
+		t.Fatalf("unexpected subscriptions %+v", entries)
+	}
+}
+
+func TestRequestAccess(t *testing.T) {
+	harness := newCommandHarness(t)
+	fake := harness.fake
+
+	harness.send(guest_id, "/request_access please")
+	request := fake.expect_message(t, admin_id, "user (id: 3, @user) requests access:\nplease")
+	if !strings.Contains(request.ReplyMarkup, `"callback_data":"access:approve:3"`) {
+		t.Fatalf("missing button in %s", request.ReplyMarkup)
+	}
+	fake.expect_message(t, guest_id, "Your request was sent to the admins. You will be notified when they decide")
+
+	// Repeating the request does not reach the admins again.
+	harness.send(guest_id, "/request_access please!")
+	fake.expect_message(t, guest_id, "Your request is still pending. You will be notified when the admins decide")
+	fake.expect_none(t)
+
+	harness.press(admin_id, "access:deny:3")
+	fake.expect_message(t, admin_id, "\n\nDenied by user")
+	fake.expect_message(t, guest_id, "Your access request was denied")
+	fake.next(t)
+	// The decision is final, pressing another button changes nothing.
+	harness.press(admin_id, "access:approve:3")
+	fake.expect_message(t, admin_id, "\n\nAlready decided")
+	fake.next(t)
+	if ok, _ := harness.repository.IsOnWhitelist(guest_id); ok {
+		t.Fatal("denied user was whitelisted")
+	}
+
+	// Once decided, the user may ask again.
+	harness.send(guest_id, "/request_access")
+	fake.expect_message(t, admin_id, "user (id: 3, @user) requests access")
+	fake.expect_message(t, guest_id, "Your request was sent to the admins. You will be notified when they decide")
+	harness.press(admin_id, "access:approve:3")
+	if request := fake.next(t); request.Method != "getChat" {
+		t.Fatalf("got %s, want getChat", request.Method)
+	}
+	fake.expect_message(t, admin_id, "\n\nApproved by user")
+	fake.expect_message(t, guest_id, "Your access request was approved. Type /help to see available commands")
+	if ok, _ := harness.repository.IsOnWhitelist(guest_id); !ok {
+		t.Fatal("approved user was not whitelisted")
+	}
+}
+
+func TestMovesCommand(t *testing.T) {
+	harness := newCommandHarness(t)
+	fake := harness.fake
+
+	harness.send(member_id, "/moves maybe")
+	fake.expect_message(t, member_id, "Usage: /moves on, /moves off")
+	harness.send(member_id, "/moves on")
+	fake.expect_message(t, member_id, "You will be notified when subscribed users switch channels")
+	if settings, _ := harness.repository.GetUserSettings(member_id); !settings.NotifyMoves {
+		t.Fatal("moves were not enabled")
+	}
+	harness.send(member_id, "/moves off")
+	fake.expect_message(t, member_id, "You will no longer be notified when subscribed users switch channels")
+	if settings, _ := harness.repository.GetUserSettings(member_id); settings.NotifyMoves {
+		t.Fatal("moves were not disabled")
+	}
+}
+
+func TestTemplateCommand(t *testing.T) {
+	harness := newCommandHarness(t)
+	fake := harness.fake
+
+	harness.send(member_id, `/template set connect "{name} joined {nowhere}"`)
+	fake.expect_message(t, member_id, "Invalid template: unknown placeholder {nowhere}")
+	harness.send(member_id, `/template set sometimes "{name}"`)
+	fake.expect_message(t, member_id, "Template must be either connect or disconnect")
+	harness.send(member_id, `/template set connect "{name} joined {channel}"`)
+	fake.expect_message(t, member_id, "Template saved. Preview:\nAnna joined Lobby")
+	if settings, _ := harness.repository.GetUserSettings(member_id); settings.ConnectTemplate != "{name} joined {channel}" {
+		t.Fatalf("unexpected settings %+v", settings)
+	}
+	harness.send(member_id, "/template reset connect")
+	fake.expect_message(t, member_id, "Template saved. Preview:\nClient Anna connected")
+}
+
+func TestQuietCommands(t *testing.T) {
+	harness := newCommandHarness(t)
+	fake := harness.fake
+
+	harness.send(member_id, "/quiet")
+	fake.expect_message(t, member_id, "Quiet hours are off")
+	harness.send(member_id, "/quiet 23:00-08:00 Nowhere/City")
+	fake.expect_message(t, member_id, "Unknown timezone Nowhere/City, use a name like Europe/Warsaw")
+	harness.send(member_id, "/quiet 23:00-08:00 Europe/Warsaw mute")
+	fake.expect_message(t, member_id, "Quiet hours: 23:00-08:00 Europe/Warsaw, notifications are muted")
+	harness.send(member_id, "/quiet off")
+	fake.expect_message(t, member_id, "Quiet hours are off")
+	if settings, _ := harness.repository.GetUserSettings(member_id); settings.Timezone != "Europe/Warsaw" {
+		t.Fatalf("timezone was not kept: %+v", settings)
+	}
+
+	harness.send(member_id, "/dnd 1000h")
+	fake.expect_message(t, member_id, "Invalid duration, use something like 2h or 45m (at most 168h)")
+	harness.send(member_id, "/dnd 2h")
+	request := fake.next(t)
+	if !strings.HasPrefix(request.Text, "Do not disturb until ") {
+		t.Fatalf("unexpected reply %q", request.Text)
+	}
+	harness.send(member_id, "/dnd off")
+	fake.expect_message(t, member_id, "Do not disturb is off")
+}
+
+func TestDigestCommand(t *testing.T) {
+	harness := newCommandHarness(t)
+	fake := harness.fake
+
+	harness.send(member_id, "/digest weekly")
+	fake.expect_message(t, member_id, "Usage: /digest hourly, /digest daily, /digest off")
+	harness.send(member_id, "/digest daily")
+	fake.expect_message(t, member_id, "You will receive a daily digest at midnight instead of individual notifications")
+	harness.send(member_id, "/digest")
+	fake.expect_message(t, member_id, "You receive a daily digest")
+
+	// Turning digests off sends what was collected so far.
+	harness.repository.AddDigestEvent(member_id, DigestEvent{Kind: template_connect, TsId: "10", Name: "Alice", Time: time.Now()})
+	harness.send(member_id, "/digest off")
+	request := fake.next(t)
+	if !strings.HasPrefix(request.Text, "Activity since ") {
+		t.Fatalf("unexpected message %q", request.Text)
+	}
+	fake.expect_message(t, member_id, "Digest mode is off, notifications are sent as they happen")
+}
+
+func TestGroupCommand(t *testing.T) {
+	harness := newCommandHarness(t)
+	fake := harness.fake
+
+	harness.send(admin_id, "/group list")
+	fake.expect_message(t, admin_id, "No chats are bound")
+	harness.send(admin_id, "/group bind 0")
+	fake.expect_message(t, admin_id, "Threshold must be a positive number")
+	harness.send(admin_id, "/group bind 2")
+	fake.expect_message(t, admin_id, "This chat will be notified when at least 2 people are on Teamspeak and when it is empty again")
+	harness.send(admin_id, "/group list")
+	fake.expect_message(t, admin_id, "Bound chats:\nuser (1) - at least 2 online\n")
+	harness.send(admin_id, "/group unbind")
+	fake.expect_message(t, admin_id, "This chat will no longer be notified")
+	harness.send(admin_id, "/group unbind")
+	fake.expect_message(t, admin_id, "This chat is not bound")
+}
+
+func TestSeenAndHistoryCommands(t *testing.T) {
+	harness := newCommandHarness(t)
+	fake := harness.fake
+	start := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	harness.server.ClientEnter(ts3fake.Client{DatabaseID: 10, Nickname: "Alice"})
+	harness.server.ClientEnter(ts3fake.Client{DatabaseID: 11, Nickname: "Bob"})
+	harness.repository.SetSession(Session{Id: "a", TsId: "10", Nickname: "Alice", Channel: "Lobby", Start: start, End: start.Add(90 * time.Minute), Reason: "connection lost"})
+
+	harness.send(member_id, "/seen carol")
+	fake.expect_message(t, member_id, "No Teamspeak user matches carol")
+	harness.send(member_id, "/seen bob")
+	fake.expect_message(t, member_id, "Bob (11) was never seen by the bot\n")
+	harness.send(member_id, "/seen alice")
+	request := fake.next(t)
+	if !strings.HasPrefix(request.Text, "Alice (10) was last seen 2024-01-15 11:30 (") {
+		t.Fatalf("unexpected reply %q", request.Text)
+	}
+
+	harness.send(member_id, "/history 10 0")
+	fake.expect_message(t, member_id, "Days must be a positive number")
+	harness.send(member_id, "/history 10")
+	fake.expect_message(t, member_id, "No sessions of 10 in the last 7 days")
+	days := int(time.Since(start).Hours()/24) + 1
+	harness.send(member_id, fmt.Sprintf("/history 10 %d", days))
+	fake.expect_message(t, member_id, fmt.Sprintf("Sessions of Alice in the last %d days:\n2024-01-15 10:00 - 11:30 (1h30m) in Lobby, connection lost\n", days))
+}
+
+func TestStatsCommand(t *testing.T) {
+	harness := newCommandHarness(t)
+	fake := harness.fake
+
+	harness.send(member_id, "/stats yesterday")
+	fake.expect_message(t, member_id, "Usage: /stats [week|month|all] [csv]")
+	harness.send(member_id, "/stats all")
+	fake.expect_message(t, member_id, "No activity recorded of all time")
+
+	harness.repository.AddPlaytime(PlaytimeCounter{TsId: "10", Nickname: "Alice", Day: "2024-01-15", Seconds: 5400, Hours: map[string]int64{"20": 3600, "21": 1800}})
+	harness.repository.AddPlaytime(PlaytimeCounter{TsId: "11", Nickname: "Bob", Day: "2024-01-15", Seconds: 1800, Hours: map[string]int64{"21": 1800}})
+	harness.send(member_id, "/stats all csv")
+	fake.expect_message(t, member_id, "Statistics of all time:\n\nLeaderboard:\n1. Alice - 1h30m\n2. Bob - 30m\nBusiest hours: 20:00, 21:00\n")
+	if request := fake.next(t); request.Method != "sendDocument" {
+		t.Fatalf("got %s, want the CSV file", request.Method)
+	}
+}
+
+func TestQuoteCommands(t *testing.T) {
+	harness := newCommandHarness(t)
+	fake := harness.fake
+	harness.server.AddChannel(ts3fake.Channel{ID: 2, Name: "Quotes"})
+	harness.repository.SetQuotesChannel("Quotes")
+
+	harness.send(member_id, `/addquote Alice "to be or not"`)
+	request := fake.next(t)
+	uuid, ok := strings.CutPrefix(request.Text, "Quote added with ID: ")
+	if !ok {
+		t.Fatalf("unexpected reply %q", request.Text)
+	}
+	fake.expect_message(t, member_id, "Updated Teamspeak quotes")
+	channel, _ := harness.server.Channel(2)
+	if !strings.Contains(channel.Description, "to be or not") {
+		t.Fatalf("quote missing from channel description %q", channel.Description)
+	}
+
+	harness.send(member_id, "/deletequote "+uuid)
+	fake.expect_message(t, member_id, "You are not allowed to use this command")
+	// Deleting asks for confirmation with a button.
+	harness.send(admin_id, "/deletequote "+uuid)
+	fake.expect_message(t, admin_id, `Delete "to be or not" by Alice?`)
+	harness.press(admin_id, "deletequote:cancel")
+	fake.expect_message(t, admin_id, "Quote was not deleted")
+	fake.next(t)
+	harness.press(member_id, "deletequote:"+uuid)
+	if request := fake.next(t); request.Method != "answerCallbackQuery" || request.Text != "You are not allowed to use this command" {
+		t.Fatalf("unexpected answer %+v", request)
+	}
+	harness.press(admin_id, "deletequote:"+uuid)
+	fake.expect_message(t, admin_id, "Quote deleted, updated Teamspeak quotes")
+	fake.next(t)
+	channel, _ = harness.server.Channel(2)
+	if strings.Contains(channel.Description, "to be or not") {
+		t.Fatalf("deleted quote still in channel description %q", channel.Description)
+	}
+	harness.send(admin_id, "/deletequote "+uuid)
+	fake.expect_message(t, admin_id, "Quote not found")
+}
+
+func TestListQuotesCommand(t *testing.T) {
+	harness := newCommandHarness(t)
+	fake := harness.fake
+
+	harness.send(member_id, "/listquotes")
+	fake.expect_message(t, member_id, "No quotes found")
+
+	harness.repository.AddQuote(Quote{UUID: "a", Author: "Alice", Content: "to be or not"})
+	harness.repository.AddQuote(Quote{UUID: "b", Author: "Bob", Content: "that is the question"})
+	harness.send(member_id, "/listquotes")
+	fake.expect_message(t, member_id, "\"to be or not\" by Alice\n\"that is the question\" by Bob\n")
+	harness.send(member_id, "/listquotes id")
+	fake.expect_message(t, member_id, "ID: a - \"to be or not\" by Alice\nID: b - \"that is the question\" by Bob\n")
+
+	// Longer lists are split into pages with buttons to move between them.
+	for i := 0; i < quotes_page_size; i++ {
+		harness.repository.AddQuote(Quote{UUID: fmt.Sprint("c", i), Author: "Carol", Content: fmt.Sprint("line ", i)})
+	}
+	harness.send(member_id, "/listquotes")
+	request := fake.next(t)
+	if !strings.HasSuffix(request.Text, "Page 1 of 2") || !strings.Contains(request.ReplyMarkup, `"callback_data":"quotes:1:0"`) {
+		t.Fatalf("unexpected first page %q with %s", request.Text, request.ReplyMarkup)
+	}
+	harness.press(member_id, "quotes:1:0")
+	request = fake.next(t)
+	if request.Method != "editMessageText" || !strings.HasSuffix(request.Text, "Page 2 of 2") ||
+		!strings.Contains(request.ReplyMarkup, `"callback_data":"quotes:0:0"`) {
+		t.Fatalf("unexpected second page %s %q with %s", request.Method, request.Text, request.ReplyMarkup)
+	}
+}
+
+func TestRelayCommand(t *testing.T) {
+	harness := newCommandHarness(t)
+	fake := harness.fake
+	harness.server.AddChannel(ts3fake.Channel{ID: 2, Name: "Lobby"})
+
+	harness.send(admin_id, "/relay status")
+	fake.expect_message(t, admin_id, "Relay is not active")
+	harness.send(admin_id, "/relay set Nowhere")
+	request := fake.next(t)
+	if !strings.HasPrefix(request.Text, "Error setting up relay: ") {
+		t.Fatalf("unexpected reply %q", request.Text)
+	}
+	harness.send(admin_id, "/relay set Lobby")
+	fake.expect_message(t, admin_id, "Messages in this chat are now relayed to the Teamspeak channel Lobby")
+	harness.send(admin_id, "/relay status")
+	fake.expect_message(t, admin_id, "Relaying Teamspeak channel Lobby to Telegram chat 1")
+
+	// Plain messages of the relayed chat go to the channel, commands don't.
+	harness.send(admin_id, "hello there")
+	wait_until(t, "the relayed message", func() bool {
+		for _, cmd := range harness.server.Received() {
+			if cmd.Name == "sendtextmessage" && cmd.Args["msg"] == "[b]user:[/b] hello there" {
+				return true
+			}
+		}
+		return false
+	})
+
+	harness.send(admin_id, "/relay off")
+	fake.expect_message(t, admin_id, "Relay disabled")
+	if _, err := harness.repository.GetChatRelay(); err != ErrNotFound {
+		t.Fatalf("relay still stored: %v", err)
+	}
+}
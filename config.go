@@ -1,11 +1,20 @@
 package main
 
 import (
+	"fmt"
 	"gopkg.in/yaml.v3"
 	"log"
 	"os"
+	"reflect"
+	"strconv"
+	"strings"
 )
 
+// env_prefix starts the environment variables overriding the config file.
+// The rest of the name is the yaml path in upper case, e.g.
+// BRIDGE_BOT_TELEGRAM_TOKEN for bot.telegram_token.
+const env_prefix = "BRIDGE"
+
 type Config struct {
 	Bot struct {
 		TelegramToken      string  `yaml:"telegram_token"`
@@ -14,20 +23,26 @@ type Config struct {
 		TeamspeakQueryPort int     `yaml:"teamspeak_query_port"`
 		TeamspeakUser      string  `yaml:"teamspeak_user"`
 		TeamspeakPassword  string  `yaml:"teamspeak_password"`
+		TeamspeakKeepalive int     `yaml:"teamspeak_keepalive"`
 		AdminIds           []int64 `yaml:"admin_ids"`
 		MongodbUri         string  `yaml:"mongodb_uri"`
 	} `yaml:"bot"`
+	Storage struct {
+		Driver string `yaml:"driver"`
+		Path   string `yaml:"path"`
+	} `yaml:"storage"`
+	Notifications struct {
+		DebounceSeconds  int  `yaml:"debounce_seconds"`
+		ReconnectMessage bool `yaml:"reconnect_message"`
+	} `yaml:"notifications"`
 }
 
-func load_config() Config {
-	config := Config{}
-
-	data, err := os.ReadFile("config.yaml")
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	err = yaml.Unmarshal(data, &config)
+// load_config reads the config file at path and applies the BRIDGE_*
+// environment variables on top. A missing file is only fine if required is
+// false, i.e. the default path is used, and the environment sets everything
+// required.
+func load_config(path string, required bool) Config {
+	config, err := read_config(path, required, os.LookupEnv)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -53,9 +68,131 @@ func load_config() Config {
 	if len(config.Bot.AdminIds) == 0 {
 		log.Fatal("Admin IDs not set")
 	}
-	if config.Bot.MongodbUri == "" {
-		log.Fatal("MongoDB URI not set")
+	if config.Storage.Driver == "" {
+		if config.Bot.MongodbUri != "" {
+			config.Storage.Driver = storage_driver_mongodb
+		} else {
+			config.Storage.Driver = storage_driver_memory
+		}
+	}
+	if config.Notifications.DebounceSeconds < 0 {
+		log.Fatal("Notification debounce must not be negative")
+	}
+	switch config.Storage.Driver {
+	case storage_driver_mongodb:
+		if config.Bot.MongodbUri == "" {
+			log.Fatal("MongoDB URI not set")
+		}
+	case storage_driver_bolt:
+		if config.Storage.Path == "" {
+			config.Storage.Path = "bridge.db"
+		}
+	case storage_driver_memory:
+	default:
+		log.Fatalf("Unknown storage driver %q", config.Storage.Driver)
 	}
-
 	return config
 }
+
+// read_config reads the config file at path, if it exists or required is
+// set, and applies the environment variables found by lookup on top.
+func read_config(path string, required bool, lookup func(string) (string, bool)) (Config, error) {
+	config := Config{}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && !required {
+		log.Printf("Config file %s not found, using environment variables only", path)
+	} else if err != nil {
+		return config, err
+	} else if err = yaml.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if err := apply_env_overrides(reflect.ValueOf(&config).Elem(), env_prefix, lookup); err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+// apply_env_overrides sets every field of the struct value for which an
+// environment variable named after its yaml path exists. NAME_FILE reads the
+// value from a file instead, which is how Docker passes secrets.
+func apply_env_overrides(value reflect.Value, prefix string, lookup func(string) (string, bool)) error {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Field(i)
+		tag, _, _ := strings.Cut(value.Type().Field(i).Tag.Get("yaml"), ",")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		name := prefix + "_" + strings.ToUpper(tag)
+		if field.Kind() == reflect.Struct {
+			if err := apply_env_overrides(field, name, lookup); err != nil {
+				return err
+			}
+			continue
+		}
+		text, ok, err := lookup_env_value(name, lookup)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if err := set_field(field, text); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func lookup_env_value(name string, lookup func(string) (string, bool)) (string, bool, error) {
+	text, ok := lookup(name)
+	path, from_file := lookup(name + "_FILE")
+	if ok && from_file {
+		return "", false, fmt.Errorf("both %s and %s_FILE are set", name, name)
+	}
+	if !from_file {
+		return text, ok, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, fmt.Errorf("%s_FILE: %w", name, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), true, nil
+}
+
+// set_field parses text into field. Lists are comma separated.
+func set_field(field reflect.Value, text string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(text)
+	case reflect.Int, reflect.Int64:
+		number, err := strconv.ParseInt(strings.TrimSpace(text), 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(number)
+	case reflect.Bool:
+		flag, err := strconv.ParseBool(strings.TrimSpace(text))
+		if err != nil {
+			return err
+		}
+		field.SetBool(flag)
+	case reflect.Slice:
+		items := reflect.MakeSlice(field.Type(), 0, 0)
+		for _, part := range strings.Split(text, ",") {
+			if strings.TrimSpace(part) == "" {
+				continue
+			}
+			item := reflect.New(field.Type().Elem()).Elem()
+			if err := set_field(item, part); err != nil {
+				return err
+			}
+			items = reflect.Append(items, item)
+		}
+		field.Set(items)
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
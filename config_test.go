@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// env_lookup looks names up in env instead of the process environment.
+func env_lookup(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+}
+
+func write_file(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadConfigOverlay(t *testing.T) {
+	path := write_file(t, "config.yaml", `
+bot:
+  telegram_token: "from file"
+  teamspeak_host: "ts.example.com"
+  teamspeak_port: 9987
+  admin_ids: [1]
+notifications:
+  reconnect_message: false
+`)
+	config, err := read_config(path, true, env_lookup(map[string]string{
+		"BRIDGE_BOT_TELEGRAM_TOKEN":              "from env",
+		"BRIDGE_BOT_TEAMSPEAK_QUERY_PORT":        " 10011 ",
+		"BRIDGE_BOT_ADMIN_IDS":                   "1, 2,",
+		"BRIDGE_STORAGE_DRIVER":                  "bolt",
+		"BRIDGE_NOTIFICATIONS_RECONNECT_MESSAGE": "true",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Bot.TelegramToken != "from env" || config.Bot.TeamspeakHost != "ts.example.com" || config.Bot.TeamspeakPort != 9987 {
+		t.Fatalf("unexpected bot config %+v", config.Bot)
+	}
+	if config.Bot.TeamspeakQueryPort != 10011 || !reflect.DeepEqual(config.Bot.AdminIds, []int64{1, 2}) {
+		t.Fatalf("unexpected bot config %+v", config.Bot)
+	}
+	if config.Storage.Driver != "bolt" || !config.Notifications.ReconnectMessage {
+		t.Fatalf("unexpected config %+v", config)
+	}
+
+	if _, err := read_config(path, true, env_lookup(map[string]string{"BRIDGE_BOT_TEAMSPEAK_PORT": "port"})); err == nil || !strings.Contains(err.Error(), "BRIDGE_BOT_TEAMSPEAK_PORT") {
+		t.Fatalf("got %v, want an error naming the variable", err)
+	}
+}
+
+func TestReadConfigFileVariant(t *testing.T) {
+	secret := write_file(t, "telegram_token", "secret token\n")
+	config, err := read_config(filepath.Join(t.TempDir(), "missing.yaml"), false, env_lookup(map[string]string{
+		"BRIDGE_BOT_TELEGRAM_TOKEN_FILE": secret,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Bot.TelegramToken != "secret token" {
+		t.Fatalf("got token %q", config.Bot.TelegramToken)
+	}
+
+	_, err = read_config(filepath.Join(t.TempDir(), "missing.yaml"), false, env_lookup(map[string]string{
+		"BRIDGE_BOT_TELEGRAM_TOKEN_FILE": filepath.Join(t.TempDir(), "missing"),
+	}))
+	if err == nil || !strings.Contains(err.Error(), "BRIDGE_BOT_TELEGRAM_TOKEN_FILE") {
+		t.Fatalf("got %v, want an error for the missing secret", err)
+	}
+}
+
+func TestReadConfigBothVariants(t *testing.T) {
+	secret := write_file(t, "telegram_token", "secret token")
+	_, err := read_config(filepath.Join(t.TempDir(), "missing.yaml"), false, env_lookup(map[string]string{
+		"BRIDGE_BOT_TELEGRAM_TOKEN":      "token",
+		"BRIDGE_BOT_TELEGRAM_TOKEN_FILE": secret,
+	}))
+	if err == nil || err.Error() != "both BRIDGE_BOT_TELEGRAM_TOKEN and BRIDGE_BOT_TELEGRAM_TOKEN_FILE are set" {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestReadConfigMissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if _, err := read_config(path, false, env_lookup(nil)); err != nil {
+		t.Fatalf("missing default config: %v", err)
+	}
+	if _, err := read_config(path, true, env_lookup(nil)); !os.IsNotExist(err) {
+		t.Fatalf("got %v, want a not exist error for an explicit path", err)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Debouncer holds back disconnect notifications for a short window. If the
+// same user connects again before it ends, both events are dropped, or
+// reported as a single reconnect when configured. This keeps unstable
+// connections from flooding subscribers.
+type Debouncer struct {
+	window            time.Duration
+	report_reconnects bool
+	send              func(SubscriberEvent)
+
+	mutex   sync.Mutex
+	pending map[string]*time.Timer
+}
+
+// NewDebouncer creates a debouncer passing the surviving events to send. A
+// zero window disables debouncing.
+func NewDebouncer(window time.Duration, report_reconnects bool, send func(SubscriberEvent)) *Debouncer {
+	return &Debouncer{
+		window:            window,
+		report_reconnects: report_reconnects,
+		send:              send,
+		pending:           make(map[string]*time.Timer),
+	}
+}
+
+// Handle passes a connect or disconnect event through the debounce window.
+func (debouncer *Debouncer) Handle(event SubscriberEvent) {
+	if debouncer.window <= 0 {
+		debouncer.send(event)
+		return
+	}
+	if event.Kind == template_disconnect {
+		debouncer.hold(event)
+		return
+	}
+	debouncer.mutex.Lock()
+	timer, held := debouncer.pending[event.TsId]
+	if held && timer.Stop() {
+		delete(debouncer.pending, event.TsId)
+	} else {
+		held = false
+	}
+	debouncer.mutex.Unlock()
+	if !held {
+		debouncer.send(event)
+	} else if debouncer.report_reconnects {
+		event.Kind = template_reconnect
+		debouncer.send(event)
+	}
+}
+
+func (debouncer *Debouncer) hold(event SubscriberEvent) {
+	debouncer.mutex.Lock()
+	defer debouncer.mutex.Unlock()
+	if timer, held := debouncer.pending[event.TsId]; held {
+		timer.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(debouncer.window, func() {
+		debouncer.mutex.Lock()
+		current := debouncer.pending[event.TsId] == timer
+		if current {
+			delete(debouncer.pending, event.TsId)
+		}
+		debouncer.mutex.Unlock()
+		if current {
+			debouncer.send(event)
+		}
+	})
+	debouncer.pending[event.TsId] = timer
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+const test_debounce_window = 50 * time.Millisecond
+
+// debounced collects the events a debouncer passes on.
+func debounced(t *testing.T, report_reconnects bool) (*Debouncer, chan SubscriberEvent) {
+	t.Helper()
+	sent := make(chan SubscriberEvent, 10)
+	debouncer := NewDebouncer(test_debounce_window, report_reconnects, func(event SubscriberEvent) {
+		sent <- event
+	})
+	return debouncer, sent
+}
+
+func expect_event(t *testing.T, sent chan SubscriberEvent, kind string) {
+	t.Helper()
+	select {
+	case event := <-sent:
+		if event.Kind != kind {
+			t.Fatalf("got %s event, want %s", event.Kind, kind)
+		}
+	case <-time.After(test_timeout):
+		t.Fatalf("timed out waiting for a %s event", kind)
+	}
+}
+
+func expect_no_event(t *testing.T, sent chan SubscriberEvent) {
+	t.Helper()
+	select {
+	case event := <-sent:
+		t.Fatalf("unexpected %s event", event.Kind)
+	case <-time.After(4 * test_debounce_window):
+	}
+}
+
+func TestDebouncerDropsQuickReconnect(t *testing.T) {
+	debouncer, sent := debounced(t, false)
+
+	debouncer.Handle(SubscriberEvent{Kind: template_disconnect, TsId: "10"})
+	debouncer.Handle(SubscriberEvent{Kind: template_connect, TsId: "10"})
+	expect_no_event(t, sent)
+}
+
+func TestDebouncerReportsReconnect(t *testing.T) {
+	debouncer, sent := debounced(t, true)
+
+	debouncer.Handle(SubscriberEvent{Kind: template_disconnect, TsId: "10"})
+	debouncer.Handle(SubscriberEvent{Kind: template_connect, TsId: "10"})
+	expect_event(t, sent, template_reconnect)
+	expect_no_event(t, sent)
+}
+
+func TestDebouncerSendsDisconnectAfterWindow(t *testing.T) {
+	debouncer, sent := debounced(t, false)
+
+	debouncer.Handle(SubscriberEvent{Kind: template_disconnect, TsId: "10"})
+	expect_event(t, sent, template_disconnect)
+	// A connect after the window is a regular connect.
+	debouncer.Handle(SubscriberEvent{Kind: template_connect, TsId: "10"})
+	expect_event(t, sent, template_connect)
+	// Connects of other users are never held back.
+	debouncer.Handle(SubscriberEvent{Kind: template_disconnect, TsId: "10"})
+	debouncer.Handle(SubscriberEvent{Kind: template_connect, TsId: "11"})
+	expect_event(t, sent, template_connect)
+	expect_event(t, sent, template_disconnect)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	digest_hourly = "hourly"
+	digest_daily  = "daily"
+
+	digest_check_interval = time.Minute
+)
+
+// digest_due returns when the pending digest should be delivered: at the end
+// of the hour or the day it was started in, in the user's timezone. A digest
+// left over after digest mode was turned off is due right away.
+func digest_due(digest PendingDigest, settings UserSettings) time.Time {
+	since := digest.Since.In(user_location(settings))
+	switch settings.DigestMode {
+	case digest_hourly:
+		return time.Date(since.Year(), since.Month(), since.Day(), since.Hour()+1, 0, 0, 0, since.Location())
+	case digest_daily:
+		return time.Date(since.Year(), since.Month(), since.Day()+1, 0, 0, 0, 0, since.Location())
+	}
+	return since
+}
+
+// run_digest_scheduler periodically delivers the digests that are due. It
+// works purely from the stored digests, so nothing is lost across restarts.
+func run_digest_scheduler(repository Store, telegram *tgbotapi.BotAPI) {
+	ticker := time.NewTicker(digest_check_interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		deliver_due_digests(now, repository, telegram)
+	}
+}
+
+func deliver_due_digests(now time.Time, repository Store, telegram *tgbotapi.BotAPI) {
+	digests, err := repository.GetPendingDigests()
+	if err != nil {
+		log.Println("Error getting pending digests:", err)
+		return
+	}
+	for _, digest := range digests {
+		telegram_id, err := strconv.ParseInt(digest.Id, 10, 64)
+		if err != nil {
+			continue
+		}
+		settings, err := repository.GetUserSettings(telegram_id)
+		if err != nil {
+			log.Println("Error getting user settings:", err)
+			continue
+		}
+		if now.Before(digest_due(digest, settings)) {
+			continue
+		}
+		// A muted user gets the digest once the mute is over.
+		if deliver, _ := notification_delivery(settings, now); !deliver {
+			continue
+		}
+		if err := deliver_digest(telegram_id, settings, repository, telegram); err != nil {
+			log.Println("Error delivering digest:", err)
+		}
+	}
+}
+
+// deliver_digest sends and clears the pending digest of a user, if any.
+func deliver_digest(telegram_id int64, settings UserSettings, repository Store, telegram *tgbotapi.BotAPI) error {
+	digest, err := repository.TakePendingDigest(telegram_id)
+	if err == ErrNotFound || (err == nil && len(digest.Events) == 0) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	message, _ := new_notification(telegram_id, format_digest(digest, user_location(settings)), settings, time.Now())
+	_, err = telegram.Send(message)
+	return err
+}
+
+// format_digest lists who came and went, pairing each connect with the
+// following disconnect into a session.
+func format_digest(digest PendingDigest, location *time.Location) string {
+	var order []string
+	events := make(map[string][]DigestEvent)
+	names := make(map[string]string)
+	for _, event := range digest.Events {
+		if _, seen := events[event.TsId]; !seen {
+			order = append(order, event.TsId)
+		}
+		events[event.TsId] = append(events[event.TsId], event)
+		names[event.TsId] = event.Name
+	}
+
+	clock := func(t time.Time) string { return t.In(location).Format("15:04") }
+	text := fmt.Sprintf("Activity since %s:\n", digest.Since.In(location).Format("2006-01-02 15:04"))
+	for _, ts_id := range order {
+		var sessions []string
+		var connected *time.Time
+		for _, event := range events[ts_id] {
+			event := event
+			if event.Kind == template_connect {
+				connected = &event.Time
+				continue
+			}
+			var session string
+			if connected != nil {
+				session = fmt.Sprintf("%s-%s (%s)", clock(*connected), clock(event.Time), format_duration(event.Time.Sub(*connected)))
+			} else {
+				session = "until " + clock(event.Time)
+			}
+			if event.Reason != "" {
+				session += ", " + event.Reason
+			}
+			sessions = append(sessions, session)
+			connected = nil
+		}
+		if connected != nil {
+			sessions = append(sessions, fmt.Sprintf("since %s, still online", clock(*connected)))
+		}
+		text += fmt.Sprintf("%s: %s\n", names[ts_id], strings.Join(sessions, "; "))
+	}
+	return text
+}
+
+// format_duration formats d rounded to minutes, like 2h5m.
+func format_duration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	if d < time.Minute {
+		return "<1m"
+	}
+	text := strings.TrimSuffix(d.String(), "0s")
+	if strings.HasSuffix(text, "h0m") {
+		text = strings.TrimSuffix(text, "0m")
+	}
+	return text
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDigestDue(t *testing.T) {
+	warsaw, err := time.LoadLocation("Europe/Warsaw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := PendingDigest{Since: time.Date(2024, 1, 15, 22, 40, 0, 0, time.UTC)}
+	tests := []struct {
+		settings UserSettings
+		due      time.Time
+	}{
+		{UserSettings{DigestMode: digest_hourly}, time.Date(2024, 1, 15, 23, 0, 0, 0, time.UTC)},
+		{UserSettings{DigestMode: digest_daily}, time.Date(2024, 1, 16, 0, 0, 0, 0, time.UTC)},
+		// 23:40 in Warsaw, the day ends twenty minutes later.
+		{UserSettings{DigestMode: digest_daily, Timezone: "Europe/Warsaw"}, time.Date(2024, 1, 16, 0, 0, 0, 0, warsaw)},
+		{UserSettings{}, digest.Since},
+	}
+	for _, test := range tests {
+		if due := digest_due(digest, test.settings); !due.Equal(test.due) {
+			t.Errorf("%+v: got %v, want %v", test.settings, due, test.due)
+		}
+	}
+}
+
+func TestFormatDigest(t *testing.T) {
+	since := time.Date(2024, 1, 15, 10, 5, 0, 0, time.UTC)
+	digest := PendingDigest{Since: since, Events: []DigestEvent{
+		{Kind: template_disconnect, TsId: "11", Name: "Bob", Reason: "connection lost", Time: since.Add(5 * time.Minute)},
+		{Kind: template_connect, TsId: "10", Name: "Alice", Time: since.Add(10 * time.Minute)},
+		{Kind: template_disconnect, TsId: "10", Name: "Alice", Time: since.Add(2*time.Hour + 15*time.Minute)},
+		{Kind: template_connect, TsId: "10", Name: "Alice", Time: since.Add(3 * time.Hour)},
+	}}
+	want := "Activity since 2024-01-15 10:05:\n" +
+		"Bob: until 10:10, connection lost\n" +
+		"Alice: 10:15-12:20 (2h5m); since 13:05, still online\n"
+	if text := format_digest(digest, time.UTC); text != want {
+		t.Fatalf("got %q, want %q", text, want)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// online_nicknames returns one nickname per connected user.
+func online_nicknames(presence *Presence) []string {
+	seen := make(map[string]bool)
+	var nicknames []string
+	for _, user := range presence.Users() {
+		if !seen[user.TsId] {
+			seen[user.TsId] = true
+			nicknames = append(nicknames, user.Nickname)
+		}
+	}
+	return nicknames
+}
+
+// update_group_bindings tells the bound group chats when the server reaches
+// their threshold and when it is empty again. It has to be called after
+// every change of the presence state.
+func update_group_bindings(presence *Presence, notifications_context *NotificationsContext) {
+	repository := notifications_context.repository
+	bindings, err := repository.GetGroupBindings()
+	if err != nil {
+		log.Println("Error getting group bindings:", err)
+		return
+	}
+	if len(bindings) == 0 {
+		return
+	}
+	nicknames := online_nicknames(presence)
+	for _, binding := range bindings {
+		var message string
+		if !binding.Notified && len(nicknames) >= binding.Threshold {
+			binding.Notified = true
+			message = group_online_message(nicknames)
+		} else if binding.Notified && len(nicknames) == 0 {
+			binding.Notified = false
+			message = "Teamspeak is empty again"
+		} else {
+			continue
+		}
+		if err := repository.SetGroupBinding(binding); err != nil {
+			log.Println("Error updating group binding:", err)
+			continue
+		}
+		if _, err := notifications_context.telegram.Send(tgbotapi.NewMessage(binding.ChatId, message)); err != nil {
+			log.Println("Error notifying group:", err)
+		}
+	}
+}
+
+func group_online_message(nicknames []string) string {
+	if len(nicknames) == 1 {
+		return fmt.Sprintf("%s is on Teamspeak — join!", nicknames[0])
+	}
+	return fmt.Sprintf("%d people are on Teamspeak: %s — join!", len(nicknames), strings.Join(nicknames, ", "))
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+
+	"bridge/ts3fake"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const test_timeout = 5 * time.Second
+
+// sentRequest is a request the bot made to the fake Telegram API.
+type sentRequest struct {
+	Method      string
+	ChatID      int64
+	Text        string
+	ReplyMarkup string
+}
+
+// fakeTelegram is a Telegram Bot API server recording every request.
+type fakeTelegram struct {
+	requests chan sentRequest
+}
+
+func newFakeTelegram(t *testing.T) (*tgbotapi.BotAPI, *fakeTelegram) {
+	t.Helper()
+	fake := &fakeTelegram{requests: make(chan sentRequest, 100)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Uploads are multipart, everything else is a plain form.
+		r.ParseMultipartForm(1 << 20)
+		method := path.Base(r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		if method == "getMe" {
+			fmt.Fprint(w, `{"ok":true,"result":{"id":1,"is_bot":true,"first_name":"Bridge","username":"bridge_bot"}}`)
+			return
+		}
+		chat_id, _ := strconv.ParseInt(r.FormValue("chat_id"), 10, 64)
+		fake.requests <- sentRequest{
+			Method:      method,
+			ChatID:      chat_id,
+			Text:        r.FormValue("text"),
+			ReplyMarkup: r.FormValue("reply_markup"),
+		}
+		fmt.Fprintf(w, `{"ok":true,"result":{"message_id":1,"date":0,"chat":{"id":%d,"type":"private"}}}`, chat_id)
+	}))
+	t.Cleanup(server.Close)
+	telegram, err := tgbotapi.NewBotAPIWithClient("token", server.URL+"/bot%s/%s", server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return telegram, fake
+}
+
+// next returns the next request the bot made.
+func (fake *fakeTelegram) next(t *testing.T) sentRequest {
+	t.Helper()
+	select {
+	case request := <-fake.requests:
+		return request
+	case <-time.After(test_timeout):
+		t.Fatal("timed out waiting for a Telegram request")
+		return sentRequest{}
+	}
+}
+
+// expect_message waits for the next message and checks its recipient and text.
+func (fake *fakeTelegram) expect_message(t *testing.T, chat_id int64, text string) sentRequest {
+	t.Helper()
+	request := fake.next(t)
+	if request.ChatID != chat_id || request.Text != text {
+		t.Fatalf("got %s to %d: %q, want to %d: %q", request.Method, request.ChatID, request.Text, chat_id, text)
+	}
+	return request
+}
+
+// expect_none checks that no request is made within a short time.
+func (fake *fakeTelegram) expect_none(t *testing.T) {
+	t.Helper()
+	select {
+	case request := <-fake.requests:
+		t.Fatalf("unexpected %s to %d: %q", request.Method, request.ChatID, request.Text)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+// newFakeTeamspeak starts a fake ServerQuery server and connects a
+// supervisor to it.
+func newFakeTeamspeak(t *testing.T) (*ts3fake.Server, *TeamspeakSupervisor) {
+	t.Helper()
+	server, err := ts3fake.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	config := &Config{}
+	config.Bot.TeamspeakHost = "127.0.0.1"
+	config.Bot.TeamspeakQueryPort = server.Port()
+	config.Bot.TeamspeakPort = 9987
+	config.Bot.TeamspeakUser = "serveradmin"
+	config.Bot.TeamspeakPassword = "secret"
+	supervisor := NewTeamspeakSupervisor(config)
+	if err := supervisor.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { supervisor.Close() })
+	return server, supervisor
+}
+
+// wait_until polls condition until it holds.
+func wait_until(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(test_timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
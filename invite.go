@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	invite_token_length   = 16
+	invite_token_alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+	default_invite_uses   = 1
+	default_invite_ttl    = 7 * 24 * time.Hour
+)
+
+// new_invite creates an invite with a random token. Tokens only use
+// characters allowed in a /start deep link payload.
+func new_invite(created_by int64, uses int, ttl time.Duration, now time.Time) (Invite, error) {
+	token, err := random_code(invite_token_length, invite_token_alphabet)
+	if err != nil {
+		return Invite{}, err
+	}
+	return Invite{
+		Token:     token,
+		CreatedBy: created_by,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+		MaxUses:   uses,
+	}, nil
+}
+
+// parse_ttl accepts Go durations like 12h as well as whole days like 7d.
+func parse_ttl(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid number of days %q", days)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if ttl <= 0 {
+		return 0, fmt.Errorf("duration must be positive")
+	}
+	return ttl, nil
+}
+
+func invite_link(bot_username string, invite Invite) string {
+	return fmt.Sprintf("https://t.me/%s?start=%s", bot_username, invite.Token)
+}
+
+func format_invite(invite Invite, now time.Time) string {
+	status := "expires in " + format_duration(invite.ExpiresAt.Sub(now))
+	if invite.Uses >= invite.MaxUses {
+		status = "used up"
+	} else if !invite.ExpiresAt.After(now) {
+		status = "expired"
+	}
+	return fmt.Sprintf("%s - used %d/%d, %s", invite.Token, invite.Uses, invite.MaxUses, status)
+}
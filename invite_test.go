@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTTL(t *testing.T) {
+	for value, want := range map[string]time.Duration{
+		"12h": 12 * time.Hour,
+		"7d":  7 * 24 * time.Hour,
+		"90m": 90 * time.Minute,
+		"0d":  0,
+		"-1h": 0,
+		"xd":  0,
+		"7":   0,
+	} {
+		got, err := parse_ttl(value)
+		if (err != nil) != (want == 0) || got != want {
+			t.Errorf("parse_ttl(%q) = %v, %v, want %v", value, got, err, want)
+		}
+	}
+}
+
+func TestInviteCommands(t *testing.T) {
+	harness := newCommandHarness(t)
+	fake := harness.fake
+
+	harness.send(admin_id, "/invite create 1 2d")
+	request := fake.next(t)
+	link, _, _ := strings.Cut(request.Text, "\n")
+	token, ok := strings.CutPrefix(link, "https://t.me/bridge_bot?start=")
+	if !ok || len(token) != invite_token_length {
+		t.Fatalf("unexpected invite %q", request.Text)
+	}
+
+	// An unknown token neither whitelists nor uses up the invite.
+	harness.send(guest_id, "/start nope")
+	fake.expect_message(t, guest_id, "This invite link is not valid")
+	if ok, _ := harness.repository.IsOnWhitelist(guest_id); ok {
+		t.Fatal("whitelisted with an unknown invite")
+	}
+
+	harness.send(guest_id, "/start "+token)
+	fake.expect_message(t, guest_id, "Welcome! You now have access. Type /help to see available commands")
+	fake.expect_message(t, admin_id, "user joined with invite "+token)
+	if ok, _ := harness.repository.IsOnWhitelist(guest_id); !ok {
+		t.Fatal("invited user was not whitelisted")
+	}
+	harness.send(guest_id, "/start "+token)
+	fake.expect_message(t, guest_id, "You already have access. Type /help to see available commands")
+
+	// The single use is gone, the next user is turned away.
+	harness.repository.RemoveWhiteListEntry(guest_id)
+	harness.send(guest_id, "/start "+token)
+	fake.expect_message(t, guest_id, "This invite link has expired or was used up")
+	if ok, _ := harness.repository.IsOnWhitelist(guest_id); ok {
+		t.Fatal("whitelisted with a used up invite")
+	}
+
+	harness.send(admin_id, "/invite list")
+	request = fake.next(t)
+	if !strings.Contains(request.Text, token+" - used 1/1, used up") {
+		t.Fatalf("unexpected invites %q", request.Text)
+	}
+	harness.send(admin_id, "/invite revoke "+token)
+	fake.expect_message(t, admin_id, "Revoked invite "+token)
+	harness.send(admin_id, "/invite revoke "+token)
+	fake.expect_message(t, admin_id, "Invite not found")
+}
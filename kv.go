@@ -0,0 +1,626 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// bucketStore is the minimal key/value interface the embedded backends have
+// to provide. Values are opaque bytes grouped in named buckets, much like
+// MongoDB collections.
+type bucketStore interface {
+	// Get returns ErrNotFound if the key does not exist.
+	Get(bucket string, key string) ([]byte, error)
+	Put(bucket string, key string, value []byte) error
+	Delete(bucket string, key string) error
+	// ForEach visits all entries of a bucket in key order.
+	ForEach(bucket string, fn func(key string, value []byte) error) error
+	// Update atomically replaces the value of key with the result of fn. fn
+	// receives nil if the key does not exist; returning nil deletes the key.
+	Update(bucket string, key string, fn func(value []byte) ([]byte, error)) error
+	Close() error
+}
+
+// KeyValueStore implements Store on top of any bucketStore by encoding the
+// documents as JSON. It backs both the in-memory and the BoltDB drivers.
+type KeyValueStore struct {
+	kv bucketStore
+}
+
+func (store *KeyValueStore) Close() error {
+	return store.kv.Close()
+}
+
+func kvGet[T any](kv bucketStore, bucket string, key string) (T, error) {
+	var result T
+	data, err := kv.Get(bucket, key)
+	if err != nil {
+		return result, err
+	}
+	err = json.Unmarshal(data, &result)
+	return result, err
+}
+
+func kvPut(kv bucketStore, bucket string, key string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return kv.Put(bucket, key, data)
+}
+
+func kvList[T any](kv bucketStore, bucket string) ([]T, error) {
+	var results []T
+	err := kv.ForEach(bucket, func(key string, value []byte) error {
+		var entry T
+		if err := json.Unmarshal(value, &entry); err != nil {
+			return err
+		}
+		results = append(results, entry)
+		return nil
+	})
+	return results, err
+}
+
+// kvUpdate decodes the current value of key (the zero value if it does not
+// exist), lets fn modify it and stores the result. If fn returns false the
+// key is deleted instead.
+func kvUpdate[T any](kv bucketStore, bucket string, key string, fn func(value *T, exists bool) (bool, error)) error {
+	return kv.Update(bucket, key, func(data []byte) ([]byte, error) {
+		var value T
+		exists := data != nil
+		if exists {
+			if err := json.Unmarshal(data, &value); err != nil {
+				return nil, err
+			}
+		}
+		keep, err := fn(&value, exists)
+		if err != nil || !keep {
+			return nil, err
+		}
+		return json.Marshal(value)
+	})
+}
+
+func (store *KeyValueStore) AddWhiteListEntry(telegram_id int64, alias string) error {
+	id := fmt.Sprintf("%d", telegram_id)
+	return kvUpdate(store.kv, whitelist_collection, id, func(entry *WhiteListEntry, exists bool) (bool, error) {
+		if exists {
+			return true, fmt.Errorf("whitelist entry %s already exists", id)
+		}
+		*entry = WhiteListEntry{Id: id, Alias: alias}
+		return true, nil
+	})
+}
+
+func (store *KeyValueStore) RemoveWhiteListEntry(telegram_id int64) error {
+	return store.kv.Delete(whitelist_collection, fmt.Sprintf("%d", telegram_id))
+}
+
+func (store *KeyValueStore) IsOnWhitelist(telegram_id int64) (bool, error) {
+	_, err := store.kv.Get(whitelist_collection, fmt.Sprintf("%d", telegram_id))
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (store *KeyValueStore) GetWhiteList() ([]WhiteListEntry, error) {
+	return kvList[WhiteListEntry](store.kv, whitelist_collection)
+}
+
+func (store *KeyValueStore) AddSubscriber(subscriber_telegram_id int64, subscribed_teamspeak_id string, subscribed_teamspeak_name string) error {
+	return kvUpdate(store.kv, subscribers_collection, subscribed_teamspeak_id, func(entry *UserSubscribers, exists bool) (bool, error) {
+		entry.Id = subscribed_teamspeak_id
+		entry.Name = subscribed_teamspeak_name
+		for _, subscriber := range entry.TelegramSubscribers {
+			if subscriber == subscriber_telegram_id {
+				return true, nil
+			}
+		}
+		entry.TelegramSubscribers = append(entry.TelegramSubscribers, subscriber_telegram_id)
+		return true, nil
+	})
+}
+
+func (store *KeyValueStore) RemoveSubscriber(subscriber_telegram_id int64, subscribed_teamspeak_id string) error {
+	return kvUpdate(store.kv, subscribers_collection, subscribed_teamspeak_id, func(entry *UserSubscribers, exists bool) (bool, error) {
+		if !exists {
+			return false, nil
+		}
+		var remaining []int64
+		for _, subscriber := range entry.TelegramSubscribers {
+			if subscriber != subscriber_telegram_id {
+				remaining = append(remaining, subscriber)
+			}
+		}
+		entry.TelegramSubscribers = remaining
+		return true, nil
+	})
+}
+
+func (store *KeyValueStore) GetSubscribers(subscribed_teamspeak_id string) (UserSubscribers, error) {
+	return kvGet[UserSubscribers](store.kv, subscribers_collection, subscribed_teamspeak_id)
+}
+
+func (store *KeyValueStore) GetSubscribedTeamspeaks(subscriber_telegram_id int64) ([]UserSubscribers, error) {
+	entries, err := kvList[UserSubscribers](store.kv, subscribers_collection)
+	if err != nil {
+		return nil, err
+	}
+	var results []UserSubscribers
+	for _, entry := range entries {
+		for _, subscriber := range entry.TelegramSubscribers {
+			if subscriber == subscriber_telegram_id {
+				results = append(results, entry)
+				break
+			}
+		}
+	}
+	return results, nil
+}
+
+func (store *KeyValueStore) GetAllSubscribers() ([]UserSubscribers, error) {
+	return kvList[UserSubscribers](store.kv, subscribers_collection)
+}
+
+func (store *KeyValueStore) AddQuote(quote Quote) error {
+	return kvUpdate(store.kv, quotes_collection, quote.UUID, func(entry *Quote, exists bool) (bool, error) {
+		if exists {
+			return true, fmt.Errorf("quote %s already exists", quote.UUID)
+		}
+		*entry = quote
+		return true, nil
+	})
+}
+
+func (store *KeyValueStore) GetAllQuotes() ([]Quote, error) {
+	return kvList[Quote](store.kv, quotes_collection)
+}
+
+func (store *KeyValueStore) DeleteQuote(uuid string) error {
+	return store.kv.Delete(quotes_collection, uuid)
+}
+
+func (store *KeyValueStore) SetProperty(property Property) error {
+	return kvPut(store.kv, properties_collection, property.ID, property)
+}
+
+func (store *KeyValueStore) GetProperty(id string, defaultValueFunc func() string) (Property, error) {
+	property, err := kvGet[Property](store.kv, properties_collection, id)
+	if err == ErrNotFound {
+		return Property{ID: id, Value: defaultValueFunc()}, nil
+	}
+	return property, err
+}
+
+func (store *KeyValueStore) GetAllProperties() ([]Property, error) {
+	return kvList[Property](store.kv, properties_collection)
+}
+
+func (store *KeyValueStore) SetQuotesChannel(value string) error {
+	return store.SetProperty(Property{ID: "quotes_channel", Value: value})
+}
+
+func (store *KeyValueStore) GetQuotesChannel() (string, bool) {
+	property, err := store.GetProperty("quotes_channel", func() string { return "" })
+	if err != nil || property.Value == "" {
+		return "", false
+	}
+	return property.Value, true
+}
+
+func (store *KeyValueStore) SetChatRelay(relay ChatRelay) error {
+	relay.Id = chat_relay_id
+	return kvPut(store.kv, relays_collection, chat_relay_id, relay)
+}
+
+func (store *KeyValueStore) GetChatRelay() (ChatRelay, error) {
+	return kvGet[ChatRelay](store.kv, relays_collection, chat_relay_id)
+}
+
+func (store *KeyValueStore) RemoveChatRelay() error {
+	return store.kv.Delete(relays_collection, chat_relay_id)
+}
+
+func (store *KeyValueStore) GetUserSettings(telegram_id int64) (UserSettings, error) {
+	id := fmt.Sprintf("%d", telegram_id)
+	settings, err := kvGet[UserSettings](store.kv, settings_collection, id)
+	if err == ErrNotFound {
+		return UserSettings{Id: id}, nil
+	}
+	return settings, err
+}
+
+func (store *KeyValueStore) SetUserSettings(settings UserSettings) error {
+	return kvPut(store.kv, settings_collection, settings.Id, settings)
+}
+
+func (store *KeyValueStore) GetAllUserSettings() ([]UserSettings, error) {
+	return kvList[UserSettings](store.kv, settings_collection)
+}
+
+func (store *KeyValueStore) AddDigestEvent(telegram_id int64, event DigestEvent) error {
+	id := fmt.Sprintf("%d", telegram_id)
+	return kvUpdate(store.kv, digests_collection, id, func(digest *PendingDigest, exists bool) (bool, error) {
+		if !exists {
+			*digest = PendingDigest{Id: id, Since: event.Time}
+		}
+		digest.Events = append(digest.Events, event)
+		return true, nil
+	})
+}
+
+func (store *KeyValueStore) GetPendingDigests() ([]PendingDigest, error) {
+	return kvList[PendingDigest](store.kv, digests_collection)
+}
+
+func (store *KeyValueStore) SetPendingDigest(digest PendingDigest) error {
+	return kvPut(store.kv, digests_collection, digest.Id, digest)
+}
+
+func (store *KeyValueStore) TakePendingDigest(telegram_id int64) (PendingDigest, error) {
+	var taken PendingDigest
+	found := false
+	err := kvUpdate(store.kv, digests_collection, fmt.Sprintf("%d", telegram_id), func(digest *PendingDigest, exists bool) (bool, error) {
+		taken, found = *digest, exists
+		return false, nil
+	})
+	if err == nil && !found {
+		err = ErrNotFound
+	}
+	return taken, err
+}
+
+func (store *KeyValueStore) SetGroupBinding(binding GroupBinding) error {
+	binding.Id = fmt.Sprintf("%d", binding.ChatId)
+	return kvPut(store.kv, groups_collection, binding.Id, binding)
+}
+
+func (store *KeyValueStore) RemoveGroupBinding(chat_id int64) error {
+	id := fmt.Sprintf("%d", chat_id)
+	if _, err := store.kv.Get(groups_collection, id); err != nil {
+		return err
+	}
+	return store.kv.Delete(groups_collection, id)
+}
+
+func (store *KeyValueStore) GetGroupBindings() ([]GroupBinding, error) {
+	return kvList[GroupBinding](store.kv, groups_collection)
+}
+
+func (store *KeyValueStore) StartSession(session Session) error {
+	session.Open = true
+	return kvPut(store.kv, sessions_collection, session.Id, session)
+}
+
+func (store *KeyValueStore) SetSession(session Session) error {
+	return kvPut(store.kv, sessions_collection, session.Id, session)
+}
+
+func (store *KeyValueStore) EndSession(ts_id string, end time.Time, reason string) error {
+	open, err := store.GetOpenSessions()
+	if err != nil {
+		return err
+	}
+	for _, session := range open {
+		if session.TsId != ts_id {
+			continue
+		}
+		err := kvUpdate(store.kv, sessions_collection, session.Id, func(session *Session, exists bool) (bool, error) {
+			session.End, session.Reason, session.Open = end, reason, false
+			return exists, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findSessions scans all sessions; the embedded stores are meant for small
+// servers, where this stays cheap.
+func (store *KeyValueStore) findSessions(filter func(session Session) bool) ([]Session, error) {
+	sessions, err := kvList[Session](store.kv, sessions_collection)
+	if err != nil {
+		return nil, err
+	}
+	var results []Session
+	for _, session := range sessions {
+		if filter(session) {
+			results = append(results, session)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Start.After(results[j].Start) })
+	return results, nil
+}
+
+func (store *KeyValueStore) GetOpenSessions() ([]Session, error) {
+	return store.findSessions(func(session Session) bool { return session.Open })
+}
+
+func (store *KeyValueStore) GetSessions(ts_id string, since time.Time) ([]Session, error) {
+	return store.findSessions(func(session Session) bool {
+		return session.TsId == ts_id && (session.Open || !session.End.Before(since))
+	})
+}
+
+func (store *KeyValueStore) GetAllSessions() ([]Session, error) {
+	return store.findSessions(func(session Session) bool { return true })
+}
+
+func (store *KeyValueStore) GetLastSession(ts_id string) (Session, error) {
+	sessions, err := store.findSessions(func(session Session) bool { return session.TsId == ts_id })
+	if err != nil {
+		return Session{}, err
+	}
+	if len(sessions) == 0 {
+		return Session{}, ErrNotFound
+	}
+	return sessions[0], nil
+}
+
+func (store *KeyValueStore) AddPlaytime(counter PlaytimeCounter) error {
+	id := playtime_counter_id(counter.TsId, counter.Day)
+	return kvUpdate(store.kv, playtime_collection, id, func(entry *PlaytimeCounter, exists bool) (bool, error) {
+		if !exists {
+			*entry = PlaytimeCounter{Id: id, TsId: counter.TsId, Day: counter.Day, Hours: make(map[string]int64)}
+		}
+		entry.Nickname = counter.Nickname
+		entry.Seconds += counter.Seconds
+		for hour, seconds := range counter.Hours {
+			entry.Hours[hour] += seconds
+		}
+		return true, nil
+	})
+}
+
+func (store *KeyValueStore) GetPlaytime(since_day string) ([]PlaytimeCounter, error) {
+	counters, err := kvList[PlaytimeCounter](store.kv, playtime_collection)
+	if err != nil {
+		return nil, err
+	}
+	var results []PlaytimeCounter
+	for _, counter := range counters {
+		if counter.Day >= since_day {
+			results = append(results, counter)
+		}
+	}
+	return results, nil
+}
+
+func (store *KeyValueStore) RecordPeak(peak DailyPeak) error {
+	return kvUpdate(store.kv, peaks_collection, peak.Day, func(entry *DailyPeak, exists bool) (bool, error) {
+		if !exists || entry.Peak < peak.Peak {
+			*entry = peak
+		}
+		return true, nil
+	})
+}
+
+func (store *KeyValueStore) GetPeaks(since_day string) ([]DailyPeak, error) {
+	peaks, err := kvList[DailyPeak](store.kv, peaks_collection)
+	if err != nil {
+		return nil, err
+	}
+	var results []DailyPeak
+	for _, peak := range peaks {
+		if peak.Day >= since_day {
+			results = append(results, peak)
+		}
+	}
+	return results, nil
+}
+
+// activity_sample_key formats the sample time so that keys sort
+// chronologically.
+func activity_sample_key(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000000000Z")
+}
+
+func (store *KeyValueStore) AddActivitySample(sample ActivitySample) error {
+	return kvPut(store.kv, samples_collection, activity_sample_key(sample.Time), sample)
+}
+
+func (store *KeyValueStore) GetActivitySamples(since time.Time) ([]ActivitySample, error) {
+	samples, err := kvList[ActivitySample](store.kv, samples_collection)
+	if err != nil {
+		return nil, err
+	}
+	var results []ActivitySample
+	for _, sample := range samples {
+		if !sample.Time.Before(since) {
+			results = append(results, sample)
+		}
+	}
+	return results, nil
+}
+
+func (store *KeyValueStore) SetLinkedIdentity(identity LinkedIdentity) error {
+	identity.Id = fmt.Sprintf("%d", identity.TelegramId)
+	if previous, err := store.GetLinkedIdentityByTsId(identity.TsId); err == nil && previous.Id != identity.Id {
+		if err := store.kv.Delete(links_collection, previous.Id); err != nil {
+			return err
+		}
+	} else if err != nil && err != ErrNotFound {
+		return err
+	}
+	return kvPut(store.kv, links_collection, identity.Id, identity)
+}
+
+func (store *KeyValueStore) GetLinkedIdentity(telegram_id int64) (LinkedIdentity, error) {
+	return kvGet[LinkedIdentity](store.kv, links_collection, fmt.Sprintf("%d", telegram_id))
+}
+
+func (store *KeyValueStore) GetLinkedIdentityByTsId(ts_id string) (LinkedIdentity, error) {
+	identities, err := store.GetAllLinkedIdentities()
+	if err != nil {
+		return LinkedIdentity{}, err
+	}
+	for _, identity := range identities {
+		if identity.TsId == ts_id {
+			return identity, nil
+		}
+	}
+	return LinkedIdentity{}, ErrNotFound
+}
+
+func (store *KeyValueStore) RemoveLinkedIdentity(telegram_id int64) error {
+	id := fmt.Sprintf("%d", telegram_id)
+	if _, err := store.kv.Get(links_collection, id); err != nil {
+		return err
+	}
+	return store.kv.Delete(links_collection, id)
+}
+
+func (store *KeyValueStore) GetAllLinkedIdentities() ([]LinkedIdentity, error) {
+	return kvList[LinkedIdentity](store.kv, links_collection)
+}
+
+func (store *KeyValueStore) SetUserRole(telegram_id int64, role string) error {
+	id := fmt.Sprintf("%d", telegram_id)
+	return kvPut(store.kv, roles_collection, id, UserRole{Id: id, TelegramId: telegram_id, Role: role})
+}
+
+func (store *KeyValueStore) GetUserRole(telegram_id int64) (UserRole, error) {
+	return kvGet[UserRole](store.kv, roles_collection, fmt.Sprintf("%d", telegram_id))
+}
+
+func (store *KeyValueStore) RemoveUserRole(telegram_id int64) error {
+	id := fmt.Sprintf("%d", telegram_id)
+	if _, err := store.kv.Get(roles_collection, id); err != nil {
+		return err
+	}
+	return store.kv.Delete(roles_collection, id)
+}
+
+func (store *KeyValueStore) GetUserRoles() ([]UserRole, error) {
+	return kvList[UserRole](store.kv, roles_collection)
+}
+
+func (store *KeyValueStore) GrantPermission(command string, role string) error {
+	return kvUpdate(store.kv, permissions_collection, command, func(permission *CommandPermission, exists bool) (bool, error) {
+		permission.Command = command
+		for _, granted := range permission.Roles {
+			if granted == role {
+				return true, nil
+			}
+		}
+		permission.Roles = append(permission.Roles, role)
+		return true, nil
+	})
+}
+
+func (store *KeyValueStore) RevokePermission(command string, role string) error {
+	return kvUpdate(store.kv, permissions_collection, command, func(permission *CommandPermission, exists bool) (bool, error) {
+		for i, granted := range permission.Roles {
+			if granted == role {
+				permission.Roles = append(permission.Roles[:i], permission.Roles[i+1:]...)
+				return len(permission.Roles) > 0, nil
+			}
+		}
+		return exists, ErrNotFound
+	})
+}
+
+func (store *KeyValueStore) GetPermission(command string) (CommandPermission, error) {
+	return kvGet[CommandPermission](store.kv, permissions_collection, command)
+}
+
+func (store *KeyValueStore) GetPermissions() ([]CommandPermission, error) {
+	return kvList[CommandPermission](store.kv, permissions_collection)
+}
+
+func (store *KeyValueStore) AddAdmin(admin AdminEntry) error {
+	admin.Id = fmt.Sprintf("%d", admin.TelegramId)
+	return kvPut(store.kv, admins_collection, admin.Id, admin)
+}
+
+func (store *KeyValueStore) RemoveAdmin(telegram_id int64) error {
+	id := fmt.Sprintf("%d", telegram_id)
+	if _, err := store.kv.Get(admins_collection, id); err != nil {
+		return err
+	}
+	return store.kv.Delete(admins_collection, id)
+}
+
+func (store *KeyValueStore) IsAdmin(telegram_id int64) (bool, error) {
+	_, err := store.kv.Get(admins_collection, fmt.Sprintf("%d", telegram_id))
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (store *KeyValueStore) GetAdmins() ([]AdminEntry, error) {
+	return kvList[AdminEntry](store.kv, admins_collection)
+}
+
+func (store *KeyValueStore) AddAccessRequest(request AccessRequest, since time.Time) error {
+	request.Id = fmt.Sprintf("%d", request.TelegramId)
+	return kvUpdate(store.kv, access_requests_collection, request.Id, func(entry *AccessRequest, exists bool) (bool, error) {
+		if exists && !entry.RequestedAt.Before(since) {
+			return true, ErrAccessRequestPending
+		}
+		*entry = request
+		return true, nil
+	})
+}
+
+func (store *KeyValueStore) RemoveAccessRequest(telegram_id int64) error {
+	id := fmt.Sprintf("%d", telegram_id)
+	if _, err := store.kv.Get(access_requests_collection, id); err != nil {
+		return err
+	}
+	return store.kv.Delete(access_requests_collection, id)
+}
+
+func (store *KeyValueStore) GetAccessRequests() ([]AccessRequest, error) {
+	return kvList[AccessRequest](store.kv, access_requests_collection)
+}
+
+func (store *KeyValueStore) AddInvite(invite Invite) error {
+	return kvUpdate(store.kv, invites_collection, invite.Token, func(entry *Invite, exists bool) (bool, error) {
+		if exists {
+			return true, fmt.Errorf("invite %s already exists", invite.Token)
+		}
+		*entry = invite
+		return true, nil
+	})
+}
+
+func (store *KeyValueStore) UseInvite(token string, now time.Time) (Invite, error) {
+	var used Invite
+	err := kvUpdate(store.kv, invites_collection, token, func(invite *Invite, exists bool) (bool, error) {
+		if !exists {
+			return false, ErrNotFound
+		}
+		if !invite.ExpiresAt.After(now) || invite.Uses >= invite.MaxUses {
+			return true, ErrInviteUnusable
+		}
+		invite.Uses++
+		used = *invite
+		return true, nil
+	})
+	return used, err
+}
+
+func (store *KeyValueStore) RemoveInvite(token string) error {
+	if _, err := store.kv.Get(invites_collection, token); err != nil {
+		return err
+	}
+	return store.kv.Delete(invites_collection, token)
+}
+
+func (store *KeyValueStore) GetInvites() ([]Invite, error) {
+	invites, err := kvList[Invite](store.kv, invites_collection)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(invites, func(i, j int) bool {
+		return invites[i].CreatedAt.Before(invites[j].CreatedAt)
+	})
+	return invites, nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/multiplay/go-ts3"
+)
+
+const (
+	link_code_length   = 6
+	link_code_alphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	link_code_ttl      = 10 * time.Minute
+)
+
+type pendingLink struct {
+	telegram_id int64
+	expires     time.Time
+}
+
+// Linker pairs Telegram users with Teamspeak identities. /link hands out a
+// one-time code which the user sends to the bot as a private Teamspeak
+// message; the code proves both accounts belong to the same person. Codes are
+// short-lived and only kept in memory. Pokes cannot carry a code, as
+// ServerQuery clients are not notified about them.
+type Linker struct {
+	repository Store
+	teamspeak  TeamspeakClient
+	telegram   *tgbotapi.BotAPI
+
+	mutex sync.Mutex
+	codes map[string]pendingLink
+}
+
+func NewLinker(repository Store, teamspeak TeamspeakClient, telegram *tgbotapi.BotAPI) *Linker {
+	return &Linker{
+		repository: repository,
+		teamspeak:  teamspeak,
+		telegram:   telegram,
+		codes:      make(map[string]pendingLink),
+	}
+}
+
+// NewCode creates a code for the Telegram user, replacing any earlier one.
+func (linker *Linker) NewCode(telegram_id int64) (string, error) {
+	code, err := random_code(link_code_length, link_code_alphabet)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	linker.mutex.Lock()
+	defer linker.mutex.Unlock()
+	linker.purge(now)
+	for existing, pending := range linker.codes {
+		if pending.telegram_id == telegram_id {
+			delete(linker.codes, existing)
+		}
+	}
+	linker.codes[code] = pendingLink{telegram_id: telegram_id, expires: now.Add(link_code_ttl)}
+	return code, nil
+}
+
+// random_code returns length characters picked at random from alphabet.
+func random_code(length int, alphabet string) (string, error) {
+	code := make([]byte, length)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+		if err != nil {
+			return "", err
+		}
+		code[i] = alphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// take returns and invalidates the Telegram user a code was issued to.
+func (linker *Linker) take(code string) (int64, bool) {
+	linker.mutex.Lock()
+	defer linker.mutex.Unlock()
+	linker.purge(time.Now())
+	pending, ok := linker.codes[code]
+	if !ok {
+		return 0, false
+	}
+	delete(linker.codes, code)
+	return pending.telegram_id, true
+}
+
+// purge drops the codes that expired before now. The caller holds the mutex.
+func (linker *Linker) purge(now time.Time) {
+	for code, pending := range linker.codes {
+		if now.After(pending.expires) {
+			delete(linker.codes, code)
+		}
+	}
+}
+
+// looks_like_code reports whether text could be a code handed out by
+// NewCode, so that other private messages to the bot are ignored.
+func looks_like_code(text string) bool {
+	if len(text) != link_code_length {
+		return false
+	}
+	for _, char := range text {
+		if !strings.ContainsRune(link_code_alphabet, char) {
+			return false
+		}
+	}
+	return true
+}
+
+// FromTeamspeak handles a private textmessage notification sent by user.
+// Messages that do not look like a code are ignored.
+func (linker *Linker) FromTeamspeak(notification ts3.Notification, user TeamspeakUser) {
+	code := strings.ToUpper(strings.TrimSpace(notification.Data["msg"]))
+	if !looks_like_code(code) {
+		return
+	}
+	telegram_id, ok := linker.take(code)
+	if !ok {
+		linker.reply(notification.Data["invokerid"], "Unknown or expired code. Send /link to the Telegram bot to get a new one.")
+		return
+	}
+	identity := LinkedIdentity{TelegramId: telegram_id, TsId: user.TsId, Nickname: user.Nickname, LinkedAt: time.Now()}
+	if err := linker.repository.SetLinkedIdentity(identity); err != nil {
+		log.Println("Error linking identity:", err)
+		linker.reply(notification.Data["invokerid"], "Linking failed, please try again.")
+		return
+	}
+	log.Println("Linked Telegram user", telegram_id, "to Teamspeak user", user.TsId)
+	linker.reply(notification.Data["invokerid"], "Your Teamspeak identity is now linked to Telegram.")
+	message := fmt.Sprintf("Linked to Teamspeak user %s (%s)", user.Nickname, user.TsId)
+	if _, err := linker.telegram.Send(tgbotapi.NewMessage(telegram_id, message)); err != nil {
+		log.Println("Error confirming link:", err)
+	}
+}
+
+func (linker *Linker) reply(clid string, text string) {
+	// targetmode=1 sends a private message to the client.
+	cmd := ts3.NewCmd("sendtextmessage").WithArgs(
+		ts3.NewArg("targetmode", 1),
+		ts3.NewArg("target", clid),
+		ts3.NewArg("msg", text),
+	)
+	if _, err := linker.teamspeak.ExecCmd(cmd); err != nil {
+		log.Println("Error replying on Teamspeak:", err)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLinkerCodes(t *testing.T) {
+	linker := NewLinker(NewMemoryStore(), nil, nil)
+	first, err := linker.NewCode(subscriber_id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !looks_like_code(first) {
+		t.Fatalf("code %q does not look like a code", first)
+	}
+	// A new code replaces the earlier one of the same user.
+	second, _ := linker.NewCode(subscriber_id)
+	if _, ok := linker.take(first); ok && first != second {
+		t.Fatal("replaced code still valid")
+	}
+	if telegram_id, ok := linker.take(second); !ok || telegram_id != subscriber_id {
+		t.Fatalf("got %d, %v", telegram_id, ok)
+	}
+	if _, ok := linker.take(second); ok {
+		t.Fatal("code used twice")
+	}
+
+	expired, _ := linker.NewCode(subscriber_id)
+	linker.codes[expired] = pendingLink{telegram_id: subscriber_id, expires: time.Now().Add(-time.Second)}
+	linker.NewCode(member_id)
+	if _, ok := linker.codes[expired]; ok {
+		t.Fatal("expired code not purged")
+	}
+}
+
+func TestLooksLikeCode(t *testing.T) {
+	for text, want := range map[string]bool{
+		"ABC234":  true,
+		"ABC23":   false,
+		"ABC2345": false,
+		"ABC1O0":  false,
+		"hello!":  false,
+	} {
+		if got := looks_like_code(text); got != want {
+			t.Errorf("looks_like_code(%q) = %v, want %v", text, got, want)
+		}
+	}
+}
@@ -1,34 +1,37 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
-	"github.com/multiplay/go-ts3"
 )
 
 func main() {
-  config := load_config()
-  host := fmt.Sprintf("%s:%d", config.Bot.TeamspeakHost, config.Bot.TeamspeakQueryPort)
-	teamspeak, err := ts3.NewClient(host)
-	if err != nil {
-		log.Panic(err)
-	}
-	defer teamspeak.Close()
-  if err := teamspeak.Login(config.Bot.TeamspeakUser, config.Bot.TeamspeakPassword); err != nil {
-		log.Panic(err)
-	}
-  if err := teamspeak.UsePort(config.Bot.TeamspeakPort); err != nil {
+  config_path := flag.String("config", "config.yaml", "path to the configuration file")
+  migrate := flag.Bool("migrate", false, "copy all data from MongoDB into the configured storage driver and exit")
+  flag.Parse()
+  // Only the default config file may be missing, a mistyped -config is an
+  // error.
+  explicit := false
+  flag.Visit(func(f *flag.Flag) {
+    explicit = explicit || f.Name == "config"
+  })
+  config := load_config(*config_path, explicit)
+  if *migrate {
+    if err := migrate_from_mongodb(&config); err != nil {
+      log.Fatal(err)
+    }
+    log.Println("Migration finished")
+    return
+  }
+  teamspeak := NewTeamspeakSupervisor(&config)
+  if err := teamspeak.Connect(); err != nil {
     log.Panic(err)
-  } else {
-    log.Println("Connected to Teamspeak server on port", config.Bot.TeamspeakPort)
   }
-	if v, err := teamspeak.Version(); err != nil {
-		log.Panic(err)
-	} else {
-		log.Println("Connected to Teamspeak server version", v.Version)
-	}
+	defer teamspeak.Close()
 
 	telegram, err := tgbotapi.NewBotAPI(config.Bot.TelegramToken)
 	if err != nil {
@@ -40,17 +43,28 @@ func main() {
 	u.Timeout = 60
 	telegram_updates := telegram.GetUpdatesChan(u)
 
-  repository, err := CreateRepository(&config)
+  repository, err := CreateStore(&config)
   if err != nil {
     log.Panic(err)
   }
 
+  relay := NewRelay(repository, teamspeak, telegram)
+  if err := relay.Start(); err != nil {
+    log.Println("Error starting relay:", err)
+  }
+
+  linker := NewLinker(repository, teamspeak, telegram)
+
 	command_link := NewCommandLink()
 	RegisterCommands(&command_link)
+	callback_link := NewCallbackLink(&command_link.commands)
+	RegisterCallbacks(&callback_link)
 	chain := Chain{
 		links: []ChainLink{
 			&LogLink{},
+			&RelayLink{relay: relay},
 			&command_link,
+			&callback_link,
 		},
 	}
 
@@ -58,16 +72,32 @@ func main() {
     teamspeak: teamspeak,
     repository: repository,
     telegram: telegram,
+    relay: relay,
+    linker: linker,
+  }
+  debounce_window := time.Duration(config.Notifications.DebounceSeconds) * time.Second
+  notifications_context.debouncer = NewDebouncer(debounce_window, config.Notifications.ReconnectMessage, func(event SubscriberEvent) {
+    send_message_to_subscribers(event, &notifications_context)
+  })
+  teamspeak.OnDisconnect = func(err error) {
+    notify_admins(fmt.Sprintf("Lost connection to Teamspeak: %v. Reconnecting...", err), &config, repository, telegram)
+  }
+  teamspeak.OnReconnect = func(downtime time.Duration) {
+    notify_admins(fmt.Sprintf("Connection to Teamspeak restored after %s", downtime.Round(time.Second)), &config, repository, telegram)
   }
+  go teamspeak.Run()
+  go run_digest_scheduler(repository, telegram)
   receive_notifications(&notifications_context)
 	for update := range telegram_updates {
-		if update.Message != nil {
+		if update.Message != nil || update.CallbackQuery != nil {
       context := BotContext{
         telegram: telegram,
         teamspeak: teamspeak,
         update: &update,
         config: &config,
         repository: repository,
+        relay: relay,
+        linker: linker,
       }
 			onMessage(context, &chain)
 		}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const max_match_choices = 8
+
+// match rank, better matches first
+const (
+	match_exact = iota
+	match_prefix
+	match_contains
+	match_fuzzy
+)
+
+// match_users finds the users query refers to: the user with that database
+// id, otherwise the users whose nickname matches best. Nicknames are compared
+// ignoring case, preferring an exact match over a prefix, a substring and
+// finally a nickname within a few typos of the query.
+func match_users(query string, users []TeamspeakUser) []TeamspeakUser {
+	for _, user := range users {
+		if user.TsId == query {
+			return []TeamspeakUser{user}
+		}
+	}
+	query = strings.ToLower(strings.TrimSpace(query))
+	if query == "" {
+		return nil
+	}
+	type candidate struct {
+		user     TeamspeakUser
+		rank     int
+		distance int
+	}
+	var candidates []candidate
+	best := match_fuzzy + 1
+	for _, user := range users {
+		nickname := strings.ToLower(user.Nickname)
+		rank, distance := match_fuzzy, 0
+		if nickname == query {
+			rank = match_exact
+		} else if strings.HasPrefix(nickname, query) {
+			rank = match_prefix
+		} else if strings.Contains(nickname, query) {
+			rank = match_contains
+		} else {
+			distance = levenshtein(nickname, query)
+			if distance > max_typos(query) {
+				continue
+			}
+		}
+		if rank < best {
+			best = rank
+		}
+		candidates = append(candidates, candidate{user, rank, distance})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].rank != candidates[j].rank {
+			return candidates[i].rank < candidates[j].rank
+		}
+		return candidates[i].distance < candidates[j].distance
+	})
+	var matches []TeamspeakUser
+	for _, candidate := range candidates {
+		// An exact nickname hides the looser matches.
+		if best == match_exact && candidate.rank != match_exact {
+			break
+		}
+		matches = append(matches, candidate.user)
+	}
+	return matches
+}
+
+func max_typos(query string) int {
+	typos := utf8.RuneCountInString(query) / 4
+	if typos < 1 {
+		return 1
+	}
+	return typos
+}
+
+// levenshtein returns the edit distance between two strings.
+func levenshtein(a string, b string) int {
+	first, second := []rune(a), []rune(b)
+	previous := make([]int, len(second)+1)
+	current := make([]int, len(second)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(first); i++ {
+		current[0] = i
+		for j := 1; j <= len(second); j++ {
+			cost := 1
+			if first[i-1] == second[j-1] {
+				cost = 0
+			}
+			current[j] = min_int(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(second)]
+}
+
+func min_int(values ...int) int {
+	result := values[0]
+	for _, value := range values[1:] {
+		if value < result {
+			result = value
+		}
+	}
+	return result
+}
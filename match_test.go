@@ -0,0 +1,71 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"", "abc", 3},
+		{"alice", "alice", 0},
+		{"alice", "alcie", 2},
+		{"alice", "alise", 1},
+		{"kitten", "sitting", 3},
+		{"jürgen", "jurgen", 1},
+	}
+	for _, test := range tests {
+		if got := levenshtein(test.a, test.b); got != test.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestMatchUsers(t *testing.T) {
+	users := []TeamspeakUser{
+		{TsId: "1", Nickname: "Alice"},
+		{TsId: "2", Nickname: "Alicia"},
+		{TsId: "3", Nickname: "Bob"},
+		{TsId: "4", Nickname: "Bobby Tables"},
+		{TsId: "5", Nickname: "Christopher"},
+		{TsId: "6", Nickname: "Maria"},
+		{TsId: "7", Nickname: "Mario"},
+		{TsId: "8", Nickname: "Natalia"},
+		{TsId: "9", Nickname: "Margaret"},
+		{TsId: "10", Nickname: "Margarete"},
+	}
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"database id", "5", []string{"5"}},
+		{"exact", "Bob", []string{"3"}},
+		{"exact ignoring case", "  aLICE ", []string{"1"}},
+		{"prefix", "chris", []string{"5"}},
+		{"substring", "tables", []string{"4"}},
+		{"one typo", "Cristopher", []string{"5"}},
+		{"too many typos", "Crstphr", nil},
+		{"short query allows one typo", "Bib", []string{"3"}},
+		{"exact hides looser matches", "bob", []string{"3"}},
+		{"prefixes before substrings", "ali", []string{"1", "2", "8"}},
+		{"ambiguous typo", "Marix", []string{"6", "7"}},
+		{"closest typo first", "Margarte", []string{"10", "9"}},
+		{"no match", "zed", nil},
+		{"empty", " ", nil},
+	}
+	for _, test := range tests {
+		var got []string
+		for _, user := range match_users(test.query, users) {
+			got = append(got, user.TsId)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: match_users(%q) = %v, want %v", test.name, test.query, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// memoryBuckets is a bucketStore that keeps everything in process memory.
+type memoryBuckets struct {
+	mutex   sync.RWMutex
+	buckets map[string]map[string][]byte
+}
+
+// NewMemoryStore returns a Store that lives only as long as the process. It
+// is meant for development and tests where running a database is not wanted.
+func NewMemoryStore() *KeyValueStore {
+	return &KeyValueStore{kv: &memoryBuckets{buckets: make(map[string]map[string][]byte)}}
+}
+
+func (memory *memoryBuckets) Get(bucket string, key string) ([]byte, error) {
+	memory.mutex.RLock()
+	defer memory.mutex.RUnlock()
+	value, ok := memory.buckets[bucket][key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte{}, value...), nil
+}
+
+func (memory *memoryBuckets) Put(bucket string, key string, value []byte) error {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+	memory.put(bucket, key, value)
+	return nil
+}
+
+func (memory *memoryBuckets) put(bucket string, key string, value []byte) {
+	if memory.buckets[bucket] == nil {
+		memory.buckets[bucket] = make(map[string][]byte)
+	}
+	memory.buckets[bucket][key] = append([]byte{}, value...)
+}
+
+func (memory *memoryBuckets) Delete(bucket string, key string) error {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+	delete(memory.buckets[bucket], key)
+	return nil
+}
+
+func (memory *memoryBuckets) ForEach(bucket string, fn func(key string, value []byte) error) error {
+	memory.mutex.RLock()
+	entries := memory.buckets[bucket]
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		values[i] = append([]byte{}, entries[key]...)
+	}
+	memory.mutex.RUnlock()
+
+	for i, key := range keys {
+		if err := fn(key, values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (memory *memoryBuckets) Update(bucket string, key string, fn func(value []byte) ([]byte, error)) error {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+	var current []byte
+	if value, ok := memory.buckets[bucket][key]; ok {
+		current = append([]byte{}, value...)
+	}
+	updated, err := fn(current)
+	if err != nil {
+		return err
+	}
+	if updated == nil {
+		delete(memory.buckets[bucket], key)
+	} else {
+		memory.put(bucket, key, updated)
+	}
+	return nil
+}
+
+func (memory *memoryBuckets) Close() error {
+	return nil
+}
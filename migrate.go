@@ -0,0 +1,282 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+// migrate_from_mongodb copies everything stored in the MongoDB instance at
+// bot.mongodb_uri into the store selected by storage.driver. Whitelist
+// entries, quotes, access requests, invites and playtime counters already
+// present in the target are skipped, so the migration can safely be re-run.
+func migrate_from_mongodb(config *Config) error {
+	if config.Bot.MongodbUri == "" {
+		return errors.New("mongodb_uri must be set to migrate from MongoDB")
+	}
+	if config.Storage.Driver == storage_driver_mongodb {
+		return errors.New("storage.driver must be set to the target backend, not mongodb")
+	}
+	source, err := CreateRepository(config)
+	if err != nil {
+		return err
+	}
+	target, err := CreateStore(config)
+	if err != nil {
+		return err
+	}
+	if closer, ok := target.(interface{ Close() error }); ok {
+		defer closer.Close()
+	}
+	return copyStore(source, target)
+}
+
+func copyStore(source Store, target Store) error {
+	whitelist, err := source.GetWhiteList()
+	if err != nil {
+		return fmt.Errorf("reading whitelist: %w", err)
+	}
+	for _, entry := range whitelist {
+		id, err := strconv.ParseInt(entry.Id, 10, 64)
+		if err != nil {
+			log.Println("Skipping whitelist entry with invalid ID", entry.Id)
+			continue
+		}
+		if exists, err := target.IsOnWhitelist(id); err != nil {
+			return err
+		} else if exists {
+			continue
+		}
+		if err := target.AddWhiteListEntry(id, entry.Alias); err != nil {
+			return fmt.Errorf("writing whitelist entry %s: %w", entry.Id, err)
+		}
+	}
+	log.Println("Migrated", len(whitelist), "whitelist entries")
+
+	subscribers, err := source.GetAllSubscribers()
+	if err != nil {
+		return fmt.Errorf("reading subscribers: %w", err)
+	}
+	for _, entry := range subscribers {
+		for _, subscriber := range entry.TelegramSubscribers {
+			if err := target.AddSubscriber(subscriber, entry.Id, entry.Name); err != nil {
+				return fmt.Errorf("writing subscriber %d of %s: %w", subscriber, entry.Id, err)
+			}
+		}
+	}
+	log.Println("Migrated subscribers of", len(subscribers), "Teamspeak users")
+
+	quotes, err := source.GetAllQuotes()
+	if err != nil {
+		return fmt.Errorf("reading quotes: %w", err)
+	}
+	existing, err := target.GetAllQuotes()
+	if err != nil {
+		return err
+	}
+	known := make(map[string]bool)
+	for _, quote := range existing {
+		known[quote.UUID] = true
+	}
+	for _, quote := range quotes {
+		if known[quote.UUID] {
+			continue
+		}
+		if err := target.AddQuote(quote); err != nil {
+			return fmt.Errorf("writing quote %s: %w", quote.UUID, err)
+		}
+	}
+	log.Println("Migrated", len(quotes), "quotes")
+
+	properties, err := source.GetAllProperties()
+	if err != nil {
+		return fmt.Errorf("reading properties: %w", err)
+	}
+	for _, property := range properties {
+		if err := target.SetProperty(property); err != nil {
+			return fmt.Errorf("writing property %s: %w", property.ID, err)
+		}
+	}
+	log.Println("Migrated", len(properties), "properties")
+
+	settings, err := source.GetAllUserSettings()
+	if err != nil {
+		return fmt.Errorf("reading user settings: %w", err)
+	}
+	for _, entry := range settings {
+		if err := target.SetUserSettings(entry); err != nil {
+			return fmt.Errorf("writing settings of %s: %w", entry.Id, err)
+		}
+	}
+	log.Println("Migrated settings of", len(settings), "users")
+
+	digests, err := source.GetPendingDigests()
+	if err != nil {
+		return fmt.Errorf("reading pending digests: %w", err)
+	}
+	for _, digest := range digests {
+		if err := target.SetPendingDigest(digest); err != nil {
+			return fmt.Errorf("writing pending digest of %s: %w", digest.Id, err)
+		}
+	}
+	log.Println("Migrated", len(digests), "pending digests")
+
+	bindings, err := source.GetGroupBindings()
+	if err != nil {
+		return fmt.Errorf("reading group bindings: %w", err)
+	}
+	for _, binding := range bindings {
+		if err := target.SetGroupBinding(binding); err != nil {
+			return fmt.Errorf("writing group binding %s: %w", binding.Id, err)
+		}
+	}
+	log.Println("Migrated", len(bindings), "group bindings")
+
+	sessions, err := source.GetAllSessions()
+	if err != nil {
+		return fmt.Errorf("reading sessions: %w", err)
+	}
+	for _, session := range sessions {
+		if err := target.SetSession(session); err != nil {
+			return fmt.Errorf("writing session %s: %w", session.Id, err)
+		}
+	}
+	log.Println("Migrated", len(sessions), "sessions")
+
+	playtime, err := source.GetPlaytime("")
+	if err != nil {
+		return fmt.Errorf("reading playtime: %w", err)
+	}
+	// AddPlaytime adds to a stored counter, so copying one twice would
+	// double it.
+	existing_playtime, err := target.GetPlaytime("")
+	if err != nil {
+		return err
+	}
+	known_playtime := make(map[string]bool)
+	for _, counter := range existing_playtime {
+		known_playtime[playtime_counter_id(counter.TsId, counter.Day)] = true
+	}
+	for _, counter := range playtime {
+		if known_playtime[playtime_counter_id(counter.TsId, counter.Day)] {
+			continue
+		}
+		if err := target.AddPlaytime(counter); err != nil {
+			return fmt.Errorf("writing playtime %s: %w", counter.Id, err)
+		}
+	}
+	peaks, err := source.GetPeaks("")
+	if err != nil {
+		return fmt.Errorf("reading peaks: %w", err)
+	}
+	for _, peak := range peaks {
+		if err := target.RecordPeak(peak); err != nil {
+			return fmt.Errorf("writing peak of %s: %w", peak.Day, err)
+		}
+	}
+	log.Println("Migrated playtime of", len(playtime), "user days and", len(peaks), "daily peaks")
+
+	samples, err := source.GetActivitySamples(time.Time{})
+	if err != nil {
+		return fmt.Errorf("reading activity samples: %w", err)
+	}
+	for _, sample := range samples {
+		if err := target.AddActivitySample(sample); err != nil {
+			return fmt.Errorf("writing activity sample: %w", err)
+		}
+	}
+	log.Println("Migrated", len(samples), "activity samples")
+
+	identities, err := source.GetAllLinkedIdentities()
+	if err != nil {
+		return fmt.Errorf("reading linked identities: %w", err)
+	}
+	for _, identity := range identities {
+		if err := target.SetLinkedIdentity(identity); err != nil {
+			return fmt.Errorf("writing linked identity %s: %w", identity.Id, err)
+		}
+	}
+	log.Println("Migrated", len(identities), "linked identities")
+
+	roles, err := source.GetUserRoles()
+	if err != nil {
+		return fmt.Errorf("reading user roles: %w", err)
+	}
+	for _, role := range roles {
+		if err := target.SetUserRole(role.TelegramId, role.Role); err != nil {
+			return fmt.Errorf("writing role of %s: %w", role.Id, err)
+		}
+	}
+	log.Println("Migrated", len(roles), "user roles")
+
+	permissions, err := source.GetPermissions()
+	if err != nil {
+		return fmt.Errorf("reading permissions: %w", err)
+	}
+	for _, permission := range permissions {
+		for _, role := range permission.Roles {
+			if err := target.GrantPermission(permission.Command, role); err != nil {
+				return fmt.Errorf("writing permission of %s: %w", permission.Command, err)
+			}
+		}
+	}
+	log.Println("Migrated permissions of", len(permissions), "commands")
+
+	admins, err := source.GetAdmins()
+	if err != nil {
+		return fmt.Errorf("reading admins: %w", err)
+	}
+	for _, admin := range admins {
+		if err := target.AddAdmin(admin); err != nil {
+			return fmt.Errorf("writing admin %s: %w", admin.Id, err)
+		}
+	}
+	log.Println("Migrated", len(admins), "admins")
+
+	requests, err := source.GetAccessRequests()
+	if err != nil {
+		return fmt.Errorf("reading access requests: %w", err)
+	}
+	for _, request := range requests {
+		err := target.AddAccessRequest(request, time.Time{})
+		if err != nil && err != ErrAccessRequestPending {
+			return fmt.Errorf("writing access request %s: %w", request.Id, err)
+		}
+	}
+	log.Println("Migrated", len(requests), "access requests")
+
+	invites, err := source.GetInvites()
+	if err != nil {
+		return fmt.Errorf("reading invites: %w", err)
+	}
+	existing_invites, err := target.GetInvites()
+	if err != nil {
+		return err
+	}
+	known_invites := make(map[string]bool)
+	for _, invite := range existing_invites {
+		known_invites[invite.Token] = true
+	}
+	for _, invite := range invites {
+		if known_invites[invite.Token] {
+			continue
+		}
+		if err := target.AddInvite(invite); err != nil {
+			return fmt.Errorf("writing invite %s: %w", invite.Token, err)
+		}
+	}
+	log.Println("Migrated", len(invites), "invites")
+
+	relay, err := source.GetChatRelay()
+	if err == nil {
+		if err := target.SetChatRelay(relay); err != nil {
+			return fmt.Errorf("writing chat relay: %w", err)
+		}
+		log.Println("Migrated chat relay")
+	} else if err != ErrNotFound {
+		return fmt.Errorf("reading chat relay: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCopyStoreTwice(t *testing.T) {
+	source := NewMemoryStore()
+	source.AddWhiteListEntry(member_id, "member")
+	source.AddSubscriber(member_id, "10", "Alice")
+	source.AddQuote(Quote{UUID: "q1", Author: "Alice", Content: "to be or not"})
+	source.SetQuotesChannel("Lobby")
+	source.SetUserSettings(UserSettings{Id: "2", NotifyMoves: true})
+	since := time.Date(2024, 1, 15, 10, 5, 0, 0, time.UTC)
+	source.SetPendingDigest(PendingDigest{Id: "2", Since: since, Events: []DigestEvent{
+		{Kind: template_connect, TsId: "10", Name: "Alice", Time: since.Add(time.Minute)},
+	}})
+	source.SetGroupBinding(GroupBinding{ChatId: -200, Title: "Friends", Threshold: 2})
+	start := since.Add(-2 * time.Hour)
+	source.SetSession(Session{Id: "s1", TsId: "10", Nickname: "Alice", Start: start, End: start.Add(time.Hour), Reason: "connection lost"})
+	source.StartSession(Session{Id: "s2", TsId: "10", Nickname: "Alice", Start: start.Add(90 * time.Minute)})
+	source.AddPlaytime(PlaytimeCounter{TsId: "10", Day: "2024-01-01", Seconds: 3600, Hours: map[string]int64{"20": 3600}})
+	source.RecordPeak(DailyPeak{Day: "2024-01-01", Peak: 3, Time: since})
+	source.AddActivitySample(ActivitySample{Time: since, Count: 2})
+	source.AddInvite(Invite{Token: "t", CreatedBy: 1, CreatedAt: since, ExpiresAt: since.Add(time.Hour), MaxUses: 3, Uses: 1})
+	target := NewMemoryStore()
+	for i := 0; i < 2; i++ {
+		if err := copyStore(source, target); err != nil {
+			t.Fatal(err)
+		}
+	}
+	whitelist, _ := target.GetWhiteList()
+	quotes, _ := target.GetAllQuotes()
+	if len(whitelist) != 1 || len(quotes) != 1 {
+		t.Fatalf("unexpected whitelist %+v and quotes %+v", whitelist, quotes)
+	}
+	subscribers, _ := target.GetSubscribers("10")
+	if len(subscribers.TelegramSubscribers) != 1 {
+		t.Fatalf("unexpected subscribers %+v", subscribers)
+	}
+	if channel, _ := target.GetQuotesChannel(); channel != "Lobby" {
+		t.Fatalf("got quotes channel %q", channel)
+	}
+	if settings, _ := target.GetUserSettings(member_id); !settings.NotifyMoves {
+		t.Fatalf("unexpected settings %+v", settings)
+	}
+	digests, _ := target.GetPendingDigests()
+	if len(digests) != 1 || !digests[0].Since.Equal(since) || len(digests[0].Events) != 1 {
+		t.Fatalf("unexpected digests %+v", digests)
+	}
+	bindings, _ := target.GetGroupBindings()
+	if len(bindings) != 1 || bindings[0].Threshold != 2 {
+		t.Fatalf("unexpected group bindings %+v", bindings)
+	}
+	// Counters are added up, so copying them twice must not double them.
+	playtime, _ := target.GetPlaytime("")
+	if len(playtime) != 1 || playtime[0].Seconds != 3600 || playtime[0].Hours["20"] != 3600 {
+		t.Fatalf("unexpected playtime %+v", playtime)
+	}
+	if peaks, _ := target.GetPeaks(""); len(peaks) != 1 || peaks[0].Peak != 3 {
+		t.Fatalf("unexpected peaks %+v", peaks)
+	}
+	if samples, _ := target.GetActivitySamples(time.Time{}); len(samples) != 1 || samples[0].Count != 2 {
+		t.Fatalf("unexpected samples %+v", samples)
+	}
+	if invites, _ := target.GetInvites(); len(invites) != 1 || invites[0].Uses != 1 {
+		t.Fatalf("unexpected invites %+v", invites)
+	}
+	// Sessions keep their own end, an open one stays open.
+	sessions, _ := target.GetAllSessions()
+	if len(sessions) != 2 || !sessions[0].Open || sessions[1].Open ||
+		!sessions[1].End.Equal(start.Add(time.Hour)) || sessions[1].Reason != "connection lost" {
+		t.Fatalf("unexpected sessions %+v", sessions)
+	}
+}
@@ -1,19 +1,29 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/multiplay/go-ts3"
 )
 
 type NotificationsContext struct {
-  teamspeak *ts3.Client
-  repository *Repository
+  teamspeak TeamspeakClient
+  repository Store
   telegram *tgbotapi.BotAPI
+  relay *Relay
+  debouncer *Debouncer
+  linker *Linker
 }
 
+// presence_reconcile_interval is how often the presence state built from
+// notifications is compared against a full clientlist, in case a
+// notification got lost.
+const presence_reconcile_interval = 5 * time.Minute
+
 func receive_notifications(notifications_context *NotificationsContext) {
   teamspeak := notifications_context.teamspeak
 	go func() {
@@ -22,34 +32,131 @@ func receive_notifications(notifications_context *NotificationsContext) {
       log.Println(err)
       return
     }
+    presence := NewPresence(users)
+    sync_sessions(presence, last_activity(notifications_context.repository), notifications_context)
 		notifications := teamspeak.Notifications()
 		log.Println("Listening for Teamspeak notifications")
-		teamspeak.Server.Register("server")
-		for notification := range notifications {
-      if notification.Type == "clientleftview" || notification.Type == "cliententerview" {
-        log.Println("Received Teamspeak notification:", notification.Type)
-        new_users, err := getTeamspeakUsers(teamspeak)
-        if err != nil {
-          log.Println(err)
-          continue
+		teamspeak.Register(ts3.ServerEvents)
+		teamspeak.Register(ts3.ChannelEvents)
+		teamspeak.Register(ts3.TextPrivateEvents)
+    reconcile := time.NewTicker(presence_reconcile_interval)
+    defer reconcile.Stop()
+    sample := time.NewTicker(activity_sample_interval)
+    defer sample.Stop()
+    // Samples double as proof of being connected, see last_activity, so
+    // none are taken during an outage.
+    connected := true
+		for {
+      select {
+      case notification, ok := <-notifications:
+        if !ok {
+          return
         }
-        added, removed := find_differences(users, new_users)
-        users = new_users
-        for _, user := range added {
-          log.Println("Client connected")
-          send_message_to_subscribers(user.TsId, "Client {name} connected", notifications_context)
+        if notification.Type == disconnected_notification {
+          connected = false
+        } else if notification.Type == reconnected_notification {
+          connected = true
         }
-        for _, user := range removed {
-          log.Println("Client disconnected")
-          send_message_to_subscribers(user.TsId, "Client {name} disconnected", notifications_context)
+        handle_notification(notification, presence, notifications_context)
+      case <-reconcile.C:
+        reconcile_presence(presence, notifications_context)
+      case now := <-sample.C:
+        if connected {
+          record_activity_sample(now, presence, notifications_context.repository)
         }
-      } else {
-        continue
       }
 		}
 	}()
 }
 
+func handle_notification(notification ts3.Notification, presence *Presence, notifications_context *NotificationsContext) {
+  if notification.Type == reconnected_notification {
+    // Anything that happened while disconnected is not reported, take
+    // a fresh snapshot so it does not show up as a burst of events.
+    users, err := getTeamspeakUsers(notifications_context.teamspeak)
+    if err != nil {
+      log.Println(err)
+      return
+    }
+    presence.Reset(users)
+    log.Println("Teamspeak user list re-synchronized after reconnect")
+    // Users who left during the outage were last seen while the connection
+    // was still up.
+    last_alive, err := time.Parse(time.RFC3339Nano, notification.Data["last_alive"])
+    if err != nil {
+      last_alive = time.Now()
+    }
+    sync_sessions(presence, last_alive, notifications_context)
+    update_group_bindings(presence, notifications_context)
+    if err := notifications_context.relay.Join(); err != nil {
+      log.Println("Error rejoining relay channel:", err)
+    }
+  } else if notification.Type == "cliententerview" {
+    user, first := presence.Enter(notification.Data)
+    if first {
+      log.Println("Client connected")
+      start_session(user, time.Now(), notifications_context)
+      record_peak(presence, notifications_context.repository)
+      notifications_context.debouncer.Handle(new_subscriber_event(template_connect, user, presence))
+      update_group_bindings(presence, notifications_context)
+    }
+  } else if notification.Type == "clientleftview" {
+    user, last := presence.Leave(notification.Data)
+    if last {
+      log.Println("Client disconnected")
+      event := new_subscriber_event(template_disconnect, user, presence)
+      event.Reason = disconnect_reason(notification.Data)
+      end_session(user.TsId, event.Time, event.Reason, notifications_context.repository)
+      notifications_context.debouncer.Handle(event)
+      update_group_bindings(presence, notifications_context)
+    }
+  } else if notification.Type == "clientmoved" {
+    if user, ok := presence.Move(notification.Data); ok {
+      send_move_to_subscribers(user, notifications_context)
+    }
+  } else if notification.Type == "textmessage" {
+    if notification.Data["targetmode"] == "1" {
+      // The bot's own private messages come back too, but query clients
+      // are not part of the presence state.
+      if user, ok := presence.Client(notification.Data["invokerid"]); ok {
+        notifications_context.linker.FromTeamspeak(notification, user)
+      }
+      return
+    }
+    notifications_context.relay.FromTeamspeak(notification)
+  }
+}
+
+// reconcile_presence compares the presence state with a full clientlist and
+// reports the differences, which only exist if notifications were missed.
+func reconcile_presence(presence *Presence, notifications_context *NotificationsContext) {
+  users, err := getTeamspeakUsers(notifications_context.teamspeak)
+  if err != nil {
+    log.Println(err)
+    return
+  }
+  added, removed := find_differences(presence.Users(), withoutQueryClients(users))
+  presence.Reset(users)
+  if len(added) > 0 || len(removed) > 0 {
+    log.Println("Presence reconciliation found", len(added), "missed connects and", len(removed), "missed disconnects")
+    sync_sessions(presence, time.Now(), notifications_context)
+    update_group_bindings(presence, notifications_context)
+  }
+  for _, user := range added {
+    notifications_context.debouncer.Handle(new_subscriber_event(template_connect, user, presence))
+  }
+  for _, user := range removed {
+    notifications_context.debouncer.Handle(new_subscriber_event(template_disconnect, user, presence))
+  }
+}
+
+// record_activity_sample stores how many users are online, for /chart.
+func record_activity_sample(now time.Time, presence *Presence, repository Store) {
+  if err := repository.AddActivitySample(ActivitySample{Time: now, Count: presence.Count()}); err != nil {
+    log.Println("Error recording activity sample:", err)
+  }
+}
+
 func find_differences(old []TeamspeakUser, current []TeamspeakUser) (added []TeamspeakUser, removed []TeamspeakUser) {
     oldMap := make(map[string]TeamspeakUser)
     currentMap := make(map[string]TeamspeakUser)
@@ -76,18 +183,129 @@ func find_differences(old []TeamspeakUser, current []TeamspeakUser) (added []Tea
     return added, removed
 }
 
-func send_message_to_subscribers(ts_id string, message string, notifications_context *NotificationsContext) {
+func new_subscriber_event(kind string, user TeamspeakUser, presence *Presence) SubscriberEvent {
+  return SubscriberEvent{
+    Kind: kind,
+    TsId: user.TsId,
+    Nickname: user.Nickname,
+    Cid: user.Cid,
+    OnlineCount: presence.Count(),
+    Time: time.Now(),
+  }
+}
+
+// send_message_to_subscribers renders the event with each subscriber's own
+// template and sends it to them.
+func send_message_to_subscribers(event SubscriberEvent, notifications_context *NotificationsContext) {
   repository := notifications_context.repository
   telegram := notifications_context.telegram
-  subscribers, err := repository.GetSubscribers(ts_id)
+  subscribers, err := repository.GetSubscribers(event.TsId)
   if err != nil {
-    log.Println("Error getting subscribers:", err)
+    if err != ErrNotFound {
+      log.Println("Error getting subscribers:", err)
+    }
     return
   }
   name := subscribers.Name
-  message = strings.Replace(message, "{name}", name, -1)
+  channel := ""
+  channel_resolved := false
+
+  for _, subscriber := range subscribers.TelegramSubscribers {
+    settings, err := repository.GetUserSettings(subscriber)
+    if err != nil {
+      log.Println("Error getting user settings:", err)
+    }
+    if settings.DigestMode != "" {
+      if event.Kind != template_reconnect {
+        add_to_digest(subscriber, event, name, repository)
+      }
+      continue
+    }
+    local_event := event
+    local_event.Time = event.Time.In(user_location(settings))
+    template := subscriber_template(settings, event.Kind)
+    if !channel_resolved && strings.Contains(template, "{channel}") {
+      channel_resolved = true
+      if found, err := getTeamspeakChannelById(event.Cid, notifications_context.teamspeak); err == nil {
+        channel = found.Name
+      } else {
+        log.Println("Error resolving channel:", err)
+      }
+    }
+    message, deliver := new_notification(subscriber, render_template(template, template_values(local_event, name, channel)), settings, event.Time)
+    if deliver {
+      telegram.Send(message)
+    }
+  }
+}
 
+func add_to_digest(telegram_id int64, event SubscriberEvent, name string, repository Store) {
+  digest_event := DigestEvent{Kind: event.Kind, TsId: event.TsId, Name: name, Reason: event.Reason, Time: event.Time}
+  if err := repository.AddDigestEvent(telegram_id, digest_event); err != nil {
+    log.Println("Error adding digest event:", err)
+  }
+}
+
+// send_move_to_subscribers notifies the subscribers of user who opted in to
+// channel switch messages.
+func send_move_to_subscribers(user TeamspeakUser, notifications_context *NotificationsContext) {
+  repository := notifications_context.repository
+  telegram := notifications_context.telegram
+  subscribers, err := repository.GetSubscribers(user.TsId)
+  if err != nil {
+    if err != ErrNotFound {
+      log.Println("Error getting subscribers:", err)
+    }
+    return
+  }
+  recipients := make(map[int64]UserSettings)
   for _, subscriber := range subscribers.TelegramSubscribers {
-    telegram.Send(tgbotapi.NewMessage(subscriber, message))
+    settings, err := repository.GetUserSettings(subscriber)
+    if err != nil {
+      log.Println("Error getting user settings:", err)
+      continue
+    }
+    if settings.NotifyMoves {
+      recipients[subscriber] = settings
+    }
+  }
+  if len(recipients) == 0 {
+    return
+  }
+  channel_name := user.Cid
+  if channel, err := getTeamspeakChannelById(user.Cid, notifications_context.teamspeak); err == nil {
+    channel_name = channel.Name
+  } else {
+    log.Println("Error resolving channel:", err)
+  }
+  text := fmt.Sprintf("Client %s moved to channel %s", subscribers.Name, channel_name)
+  now := time.Now()
+  for subscriber, settings := range recipients {
+    if message, deliver := new_notification(subscriber, text, settings, now); deliver {
+      telegram.Send(message)
+    }
+  }
+}
+
+// admin_ids returns the config admins and those added with /admin add.
+func admin_ids(config *Config, repository Store) []int64 {
+  ids := append([]int64{}, config.Bot.AdminIds...)
+  admins, err := repository.GetAdmins()
+  if err != nil {
+    log.Println("Error loading admins:", err)
+  }
+  for _, admin := range admins {
+    if !is_config_admin(config, admin.TelegramId) {
+      ids = append(ids, admin.TelegramId)
+    }
+  }
+  return ids
+}
+
+func notify_admins(message string, config *Config, repository Store, telegram *tgbotapi.BotAPI) {
+  for _, admin_id := range admin_ids(config, repository) {
+    if _, err := telegram.Send(tgbotapi.NewMessage(admin_id, message)); err != nil {
+      log.Println("Error notifying admin:", err)
+    }
   }
 }
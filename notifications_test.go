@@ -0,0 +1,260 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"bridge/ts3fake"
+)
+
+const (
+	subscriber_id = 42
+	relay_chat_id = -100
+	group_chat_id = -200
+)
+
+// start_notifications runs receive_notifications against a fake server and
+// waits until it registered for all notifications.
+func start_notifications(t *testing.T, repository Store) (*ts3fake.Server, *fakeTelegram, *NotificationsContext) {
+	t.Helper()
+	server, teamspeak := newFakeTeamspeak(t)
+	telegram, fake := newFakeTelegram(t)
+	notifications_context := &NotificationsContext{
+		teamspeak:  teamspeak,
+		repository: repository,
+		telegram:   telegram,
+		relay:      NewRelay(repository, teamspeak, telegram),
+		linker:     NewLinker(repository, teamspeak, telegram),
+	}
+	notifications_context.debouncer = NewDebouncer(0, false, func(event SubscriberEvent) {
+		send_message_to_subscribers(event, notifications_context)
+	})
+	receive_notifications(notifications_context)
+	wait_until(t, "notification registration", func() bool {
+		registered := 0
+		for _, cmd := range server.Received() {
+			if cmd.Name == "servernotifyregister" {
+				registered++
+			}
+		}
+		return registered == 3
+	})
+	return server, fake, notifications_context
+}
+
+func TestNotificationsEnterAndLeave(t *testing.T) {
+	repository := NewMemoryStore()
+	repository.AddSubscriber(subscriber_id, "10", "Alice")
+	server, fake, _ := start_notifications(t, repository)
+
+	clid := server.ClientEnter(ts3fake.Client{DatabaseID: 10, Nickname: "Alice"})
+	fake.expect_message(t, subscriber_id, "Client Alice connected")
+
+	// A second client of the same user is not reported again.
+	second := server.ClientEnter(ts3fake.Client{DatabaseID: 10, Nickname: "Alice"})
+	// Nobody is subscribed to Bob.
+	bob := server.ClientEnter(ts3fake.Client{DatabaseID: 11, Nickname: "Bob"})
+	server.ClientLeave(bob, 8, "")
+	fake.expect_none(t)
+
+	server.ClientLeave(second, 8, "")
+	fake.expect_none(t)
+	server.ClientLeave(clid, 3, "")
+	fake.expect_message(t, subscriber_id, "Client Alice disconnected (connection lost)")
+
+	sessions, err := repository.GetSessions("10", time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || sessions[0].Open || sessions[0].Reason != "connection lost" {
+		t.Fatalf("unexpected sessions %+v", sessions)
+	}
+}
+
+func TestNotificationsCloseSessionsOfPreviousRun(t *testing.T) {
+	repository := NewMemoryStore()
+	now := time.Now().Truncate(time.Second)
+	start := now.Add(-2 * time.Hour)
+	last_sample := now.Add(-time.Hour)
+	repository.StartSession(Session{Id: "a", TsId: "10", Nickname: "Alice", Start: start})
+	repository.AddActivitySample(ActivitySample{Time: last_sample, Count: 1})
+	start_notifications(t, repository)
+
+	// The downtime after the last sample is not counted.
+	sessions, err := repository.GetSessions("10", start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || sessions[0].Open || !sessions[0].End.Equal(last_sample) {
+		t.Fatalf("unexpected sessions %+v", sessions)
+	}
+	if seconds := playtime_seconds(t, repository); seconds["10"] != 3600 {
+		t.Fatalf("unexpected playtime %v", seconds)
+	}
+}
+
+func TestNotificationsDisconnectReason(t *testing.T) {
+	repository := NewMemoryStore()
+	repository.AddSubscriber(subscriber_id, "10", "Alice")
+	server, fake, _ := start_notifications(t, repository)
+
+	clid := server.ClientEnter(ts3fake.Client{DatabaseID: 10, Nickname: "Alice"})
+	fake.expect_message(t, subscriber_id, "Client Alice connected")
+	server.ClientLeave(clid, 5, "spamming")
+	fake.expect_message(t, subscriber_id, "Client Alice disconnected (kicked: spamming)")
+}
+
+func TestNotificationsTemplate(t *testing.T) {
+	repository := NewMemoryStore()
+	repository.AddSubscriber(subscriber_id, "10", "Alice")
+	repository.SetUserSettings(UserSettings{
+		Id:                 "42",
+		ConnectTemplate:    "{name} joined {channel} ({online_count} online)",
+		DisconnectTemplate: "{name} left {reason}",
+	})
+	server, fake, _ := start_notifications(t, repository)
+	server.ClientEnter(ts3fake.Client{DatabaseID: 11, Nickname: "Bob"})
+
+	clid := server.ClientEnter(ts3fake.Client{DatabaseID: 10, Nickname: "Alice"})
+	fake.expect_message(t, subscriber_id, "Alice joined Default Channel (2 online)")
+	server.ClientLeave(clid, 3, "")
+	fake.expect_message(t, subscriber_id, "Alice left (connection lost)")
+}
+
+func TestNotificationsDoNotDisturb(t *testing.T) {
+	repository := NewMemoryStore()
+	repository.AddSubscriber(subscriber_id, "10", "Alice")
+	repository.AddSubscriber(subscriber_id+1, "10", "Alice")
+	repository.SetUserSettings(UserSettings{Id: "42", DndUntil: time.Now().Add(time.Hour)})
+	server, fake, _ := start_notifications(t, repository)
+
+	server.ClientEnter(ts3fake.Client{DatabaseID: 10, Nickname: "Alice"})
+	fake.expect_message(t, subscriber_id+1, "Client Alice connected")
+	fake.expect_none(t)
+}
+
+func TestNotificationsDigest(t *testing.T) {
+	repository := NewMemoryStore()
+	repository.AddSubscriber(subscriber_id, "10", "Alice")
+	repository.SetUserSettings(UserSettings{Id: "42", DigestMode: digest_hourly})
+	server, fake, notifications_context := start_notifications(t, repository)
+
+	clid := server.ClientEnter(ts3fake.Client{DatabaseID: 10, Nickname: "Alice"})
+	server.ClientLeave(clid, 8, "")
+	wait_until(t, "the digest events", func() bool {
+		digests, _ := repository.GetPendingDigests()
+		return len(digests) == 1 && len(digests[0].Events) == 2
+	})
+	fake.expect_none(t)
+
+	deliver_due_digests(time.Now().Add(time.Hour), repository, notifications_context.telegram)
+	request := fake.next(t)
+	if request.ChatID != subscriber_id || !strings.Contains(request.Text, "Alice: ") {
+		t.Fatalf("unexpected digest %+v", request)
+	}
+	if digests, _ := repository.GetPendingDigests(); len(digests) != 0 {
+		t.Fatalf("digest was not cleared: %+v", digests)
+	}
+}
+
+func TestNotificationsGroupBinding(t *testing.T) {
+	repository := NewMemoryStore()
+	repository.SetGroupBinding(GroupBinding{ChatId: group_chat_id, Title: "Friends", Threshold: 2})
+	server, fake, _ := start_notifications(t, repository)
+
+	alice := server.ClientEnter(ts3fake.Client{DatabaseID: 10, Nickname: "Alice"})
+	fake.expect_none(t)
+	bob := server.ClientEnter(ts3fake.Client{DatabaseID: 11, Nickname: "Bob"})
+	fake.expect_message(t, group_chat_id, "2 people are on Teamspeak: Alice, Bob — join!")
+	server.ClientLeave(alice, 8, "")
+	fake.expect_none(t)
+	server.ClientLeave(bob, 8, "")
+	fake.expect_message(t, group_chat_id, "Teamspeak is empty again")
+}
+
+func TestReconcilePresence(t *testing.T) {
+	repository := NewMemoryStore()
+	repository.AddSubscriber(subscriber_id, "10", "Alice")
+	repository.AddSubscriber(subscriber_id, "11", "Bob")
+	server, fake, notifications_context := start_notifications(t, repository)
+	server.ClientEnter(ts3fake.Client{DatabaseID: 10, Nickname: "Alice"})
+	fake.expect_message(t, subscriber_id, "Client Alice connected")
+
+	// The notifications about Alice joining and Bob leaving were missed.
+	presence := NewPresence([]TeamspeakUser{{TsId: "11", Nickname: "Bob", Clid: "99"}})
+	reconcile_presence(presence, notifications_context)
+	fake.expect_message(t, subscriber_id, "Client Alice connected")
+	fake.expect_message(t, subscriber_id, "Client Bob disconnected")
+	fake.expect_none(t)
+}
+
+func TestNotificationsMove(t *testing.T) {
+	repository := NewMemoryStore()
+	repository.AddSubscriber(subscriber_id, "10", "Alice")
+	repository.AddSubscriber(subscriber_id+1, "10", "Alice")
+	settings, _ := repository.GetUserSettings(subscriber_id)
+	settings.NotifyMoves = true
+	repository.SetUserSettings(settings)
+	server, fake, _ := start_notifications(t, repository)
+	server.AddChannel(ts3fake.Channel{ID: 2, Name: "Lobby"})
+
+	clid := server.ClientEnter(ts3fake.Client{DatabaseID: 10, Nickname: "Alice"})
+	fake.expect_message(t, subscriber_id, "Client Alice connected")
+	fake.expect_message(t, subscriber_id+1, "Client Alice connected")
+	if err := server.ClientMove(clid, 2); err != nil {
+		t.Fatal(err)
+	}
+	// Only the subscriber who opted in hears about the move.
+	fake.expect_message(t, subscriber_id, "Client Alice moved to channel Lobby")
+	fake.expect_none(t)
+}
+
+func TestNotificationsChannelTextMessage(t *testing.T) {
+	repository := NewMemoryStore()
+	repository.SetChatRelay(ChatRelay{TelegramChatId: relay_chat_id, ChannelName: "Lobby"})
+	server, fake, notifications_context := start_notifications(t, repository)
+	server.AddChannel(ts3fake.Channel{ID: 2, Name: "Lobby"})
+	if err := notifications_context.relay.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	clid := server.ClientEnter(ts3fake.Client{DatabaseID: 10, Nickname: "Alice", ChannelID: 2})
+	if err := server.TextMessage(clid, "hello there"); err != nil {
+		t.Fatal(err)
+	}
+	fake.expect_message(t, relay_chat_id, "Alice: hello there")
+}
+
+func TestNotificationsPrivateTextMessageLinks(t *testing.T) {
+	repository := NewMemoryStore()
+	server, fake, notifications_context := start_notifications(t, repository)
+
+	clid := server.ClientEnter(ts3fake.Client{DatabaseID: 10, Nickname: "Alice"})
+	code, err := notifications_context.linker.NewCode(subscriber_id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Chatter that is not shaped like a code gets no reply.
+	if err := server.PrivateMessage(clid, "hello bot"); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.PrivateMessage(clid, strings.ToLower(code)); err != nil {
+		t.Fatal(err)
+	}
+	fake.expect_message(t, subscriber_id, "Linked to Teamspeak user Alice (10)")
+	identity, err := repository.GetLinkedIdentity(subscriber_id)
+	if err != nil || identity.TsId != "10" {
+		t.Fatalf("got %+v, %v", identity, err)
+	}
+	var replies []string
+	for _, cmd := range server.Received() {
+		if cmd.Name == "sendtextmessage" && cmd.Args["target"] == strconv.Itoa(clid) {
+			replies = append(replies, cmd.Args["msg"])
+		}
+	}
+	if len(replies) != 1 || !strings.Contains(replies[0], "now linked") {
+		t.Fatalf("unexpected replies on Teamspeak %q", replies)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Presence tracks who is online, keyed by client id (clid). It is built from
+// the payloads of cliententerview, clientleftview and clientmoved
+// notifications, so no clientlist round trip is needed per event. A single
+// Teamspeak user can be connected more than once; it only counts as connected
+// on its first client and as disconnected when its last client leaves.
+// ServerQuery clients, including the bot itself, are not tracked.
+type Presence struct {
+	mutex   sync.RWMutex
+	clients map[string]TeamspeakUser
+}
+
+func NewPresence(users []TeamspeakUser) *Presence {
+	presence := &Presence{}
+	presence.Reset(users)
+	return presence
+}
+
+// Reset replaces the state with a fresh clientlist snapshot.
+func (presence *Presence) Reset(users []TeamspeakUser) {
+	presence.mutex.Lock()
+	defer presence.mutex.Unlock()
+	presence.clients = make(map[string]TeamspeakUser)
+	for _, user := range withoutQueryClients(users) {
+		presence.clients[user.Clid] = user
+	}
+}
+
+// Enter records a cliententerview notification. It returns the user and
+// whether this is the user's first connected client. Duplicate notifications
+// (the server sends one per registered event category) and ServerQuery
+// clients are ignored.
+func (presence *Presence) Enter(data map[string]string) (user TeamspeakUser, first bool) {
+	presence.mutex.Lock()
+	defer presence.mutex.Unlock()
+	user = TeamspeakUser{
+		TsId:     data["client_database_id"],
+		Nickname: data["client_nickname"],
+		Clid:     data["clid"],
+		Cid:      data["ctid"],
+		IsQuery:  data["client_type"] == "1",
+	}
+	if _, known := presence.clients[user.Clid]; known || user.IsQuery {
+		return user, false
+	}
+	first = presence.count(user.TsId) == 0
+	presence.clients[user.Clid] = user
+	return user, first
+}
+
+// Leave records a clientleftview notification. It returns the user that left
+// and whether that was the user's last connected client.
+func (presence *Presence) Leave(data map[string]string) (user TeamspeakUser, last bool) {
+	presence.mutex.Lock()
+	defer presence.mutex.Unlock()
+	user, known := presence.clients[data["clid"]]
+	if !known {
+		return user, false
+	}
+	delete(presence.clients, user.Clid)
+	return user, presence.count(user.TsId) == 0
+}
+
+// Move records a clientmoved notification.
+func (presence *Presence) Move(data map[string]string) (TeamspeakUser, bool) {
+	presence.mutex.Lock()
+	defer presence.mutex.Unlock()
+	user, known := presence.clients[data["clid"]]
+	if !known {
+		return user, false
+	}
+	user.Cid = data["ctid"]
+	presence.clients[user.Clid] = user
+	return user, true
+}
+
+// Users returns one entry per connected client, ordered by nickname.
+func (presence *Presence) Users() []TeamspeakUser {
+	presence.mutex.RLock()
+	defer presence.mutex.RUnlock()
+	users := make([]TeamspeakUser, 0, len(presence.clients))
+	for _, user := range presence.clients {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Nickname < users[j].Nickname })
+	return users
+}
+
+// Client returns the connected client with the given clid.
+func (presence *Presence) Client(clid string) (TeamspeakUser, bool) {
+	presence.mutex.RLock()
+	defer presence.mutex.RUnlock()
+	user, ok := presence.clients[clid]
+	return user, ok
+}
+
+// Count returns the number of distinct users online.
+func (presence *Presence) Count() int {
+	presence.mutex.RLock()
+	defer presence.mutex.RUnlock()
+	users := make(map[string]bool)
+	for _, user := range presence.clients {
+		users[user.TsId] = true
+	}
+	return len(users)
+}
+
+func (presence *Presence) count(ts_id string) int {
+	count := 0
+	for _, user := range presence.clients {
+		if user.TsId == ts_id {
+			count++
+		}
+	}
+	return count
+}
+
+// disconnect_reason describes the reasonid of a clientleftview notification,
+// or returns an empty string for a regular disconnect.
+func disconnect_reason(data map[string]string) string {
+	message := data["reasonmsg"]
+	switch data["reasonid"] {
+	case "3":
+		return "connection lost"
+	case "5":
+		return with_details("kicked", data["invokername"], message)
+	case "6":
+		return with_details("banned", data["invokername"], message)
+	case "11":
+		return "server shutdown"
+	case "8":
+		if message != "" {
+			return fmt.Sprintf("left: %s", message)
+		}
+	}
+	return ""
+}
+
+func with_details(action string, invoker string, message string) string {
+	if invoker != "" {
+		action += " by " + invoker
+	}
+	if message != "" {
+		action += ": " + message
+	}
+	return action
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// unescapeQuery decodes a ServerQuery value. The protocol escapes
+// backslash, slash, space, pipe and the ASCII control characters; an unknown
+// escape sequence is kept as is.
+func unescapeQuery(value string) string {
+	if !strings.Contains(value, `\`) {
+		return value
+	}
+	var result strings.Builder
+	result.Grow(len(value))
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i+1 == len(value) {
+			result.WriteByte(value[i])
+			continue
+		}
+		i++
+		switch value[i] {
+		case '\\':
+			result.WriteByte('\\')
+		case '/':
+			result.WriteByte('/')
+		case 's':
+			result.WriteByte(' ')
+		case 'p':
+			result.WriteByte('|')
+		case 'a':
+			result.WriteByte('\a')
+		case 'b':
+			result.WriteByte('\b')
+		case 'f':
+			result.WriteByte('\f')
+		case 'n':
+			result.WriteByte('\n')
+		case 'r':
+			result.WriteByte('\r')
+		case 't':
+			result.WriteByte('\t')
+		case 'v':
+			result.WriteByte('\v')
+		default:
+			result.WriteByte('\\')
+			result.WriteByte(value[i])
+		}
+	}
+	return result.String()
+}
+
+// parseQueryResponse splits a response into its records ("|" separated) and
+// each record into its unescaped key/value pairs. Keys without a value map to
+// an empty string.
+func parseQueryResponse(lines []string) []map[string]string {
+	var records []map[string]string
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+		for _, entry := range strings.Split(line, "|") {
+			record := make(map[string]string)
+			for _, field := range strings.Split(entry, " ") {
+				if field == "" {
+					continue
+				}
+				key, value, _ := strings.Cut(field, "=")
+				record[unescapeQuery(key)] = unescapeQuery(value)
+			}
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// decodeQueryResponse parses a response into out, which must point to a
+// struct or a slice of structs. Fields are matched by their `query` tag;
+// string, bool and integer fields are supported.
+func decodeQueryResponse(lines []string, out interface{}) error {
+	records := parseQueryResponse(lines)
+	target := reflect.ValueOf(out)
+	if target.Kind() != reflect.Ptr {
+		return fmt.Errorf("decode query response: expected pointer, got %s", target.Type())
+	}
+	target = target.Elem()
+	if target.Kind() == reflect.Slice {
+		slice := reflect.MakeSlice(target.Type(), len(records), len(records))
+		for i, record := range records {
+			if err := decodeQueryRecord(record, slice.Index(i)); err != nil {
+				return err
+			}
+		}
+		target.Set(slice)
+		return nil
+	}
+	if len(records) == 0 {
+		return fmt.Errorf("decode query response: empty response")
+	}
+	return decodeQueryRecord(records[0], target)
+}
+
+func decodeQueryRecord(record map[string]string, target reflect.Value) error {
+	if target.Kind() != reflect.Struct {
+		return fmt.Errorf("decode query response: expected struct, got %s", target.Type())
+	}
+	for i := 0; i < target.NumField(); i++ {
+		key := target.Type().Field(i).Tag.Get("query")
+		value, ok := record[key]
+		if key == "" || !ok {
+			continue
+		}
+		field := target.Field(i)
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(value)
+		case reflect.Bool:
+			field.SetBool(value == "1")
+		case reflect.Int, reflect.Int64:
+			number, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("decode query response: %s: %w", key, err)
+			}
+			field.SetInt(number)
+		default:
+			return fmt.Errorf("decode query response: unsupported field type %s", field.Type())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUnescapeQuery(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"plain", "plain"},
+		{`a\\b`, `a\b`},
+		{`a\/b`, "a/b"},
+		{`a\sb`, "a b"},
+		{`a\pb`, "a|b"},
+		{`\a`, "\a"},
+		{`\b`, "\b"},
+		{`\f`, "\f"},
+		{`\n`, "\n"},
+		{`\r`, "\r"},
+		{`\t`, "\t"},
+		{`\v`, "\v"},
+		// An escaped backslash followed by s is not a space.
+		{`\\s`, `\s`},
+		{`\\\s`, `\ `},
+		{`\x`, `\x`},
+		{`trailing\`, `trailing\`},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := unescapeQuery(test.value); got != test.want {
+			t.Errorf("unescapeQuery(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestParseQueryResponse(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		want  []map[string]string
+	}{
+		{"empty", nil, nil},
+		{"empty line", []string{""}, nil},
+		{
+			"single record",
+			[]string{`clid=1 client_nickname=Alice\sSmith`},
+			[]map[string]string{{"clid": "1", "client_nickname": "Alice Smith"}},
+		},
+		{
+			"value containing =",
+			[]string{`msg=a=b\s=c`},
+			[]map[string]string{{"msg": "a=b =c"}},
+		},
+		{
+			"key without value",
+			[]string{`cid=1 -flag client_away`},
+			[]map[string]string{{"cid": "1", "-flag": "", "client_away": ""}},
+		},
+		{
+			"records",
+			[]string{`clid=1 cid=2|clid=3 cid=4|clid=5 name=a\pb`},
+			[]map[string]string{{"clid": "1", "cid": "2"}, {"clid": "3", "cid": "4"}, {"clid": "5", "name": "a|b"}},
+		},
+	}
+	for _, test := range tests {
+		if got := parseQueryResponse(test.lines); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestDecodeQueryResponse(t *testing.T) {
+	var clients []queryClient
+	err := decodeQueryResponse([]string{`clid=1 cid=2 client_database_id=10 client_nickname=A\sB client_type=0|clid=3 cid=2 client_database_id=1 client_nickname=serveradmin client_type=1`}, &clients)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []queryClient{
+		{Clid: "1", Cid: "2", DatabaseId: "10", Nickname: "A B", Type: 0},
+		{Clid: "3", Cid: "2", DatabaseId: "1", Nickname: "serveradmin", Type: 1},
+	}
+	if !reflect.DeepEqual(clients, want) {
+		t.Fatalf("got %+v, want %+v", clients, want)
+	}
+
+	var info TeamspeakServerInfo
+	if err := decodeQueryResponse([]string{"virtualserver_name=Fake virtualserver_maxclients=32"}, &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Name != "Fake" || info.MaxClients != 32 {
+		t.Fatalf("unexpected %+v", info)
+	}
+
+	if err := decodeQueryResponse(nil, &clients); err != nil || len(clients) != 0 {
+		t.Fatalf("empty list: %v, %+v", err, clients)
+	}
+	if err := decodeQueryResponse(nil, &info); err == nil {
+		t.Fatal("expected an error for an empty response")
+	}
+	if err := decodeQueryResponse([]string{"virtualserver_maxclients=many"}, &info); err == nil {
+		t.Fatal("expected an error for a malformed number")
+	}
+	if err := decodeQueryResponse([]string{"a=1"}, info); err == nil {
+		t.Fatal("expected an error for a non-pointer")
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	// The alpine image ships without a zoneinfo database, embed it so
+	// /quiet accepts IANA timezone names everywhere.
+	_ "time/tzdata"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// quiet_mode_silent delivers notifications during quiet hours without
+	// a sound, quiet_mode_mute drops them.
+	quiet_mode_silent = "silent"
+	quiet_mode_mute   = "mute"
+
+	max_dnd_duration = 7 * 24 * time.Hour
+)
+
+// parse_clock parses a "HH:MM" time of day into minutes since midnight.
+func parse_clock(value string) (int, error) {
+	clock, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	return clock.Hour()*60 + clock.Minute(), nil
+}
+
+// parse_quiet_range parses a "23:00-08:00" range into its start and end.
+func parse_quiet_range(value string) (start string, end string, err error) {
+	start, end, found := strings.Cut(value, "-")
+	if !found {
+		return "", "", fmt.Errorf("invalid range %q, expected HH:MM-HH:MM", value)
+	}
+	if _, err := parse_clock(start); err != nil {
+		return "", "", err
+	}
+	if _, err := parse_clock(end); err != nil {
+		return "", "", err
+	}
+	if start == end {
+		return "", "", fmt.Errorf("quiet hours must not start and end at the same time")
+	}
+	return start, end, nil
+}
+
+// user_location returns the timezone of the user, UTC if none is set.
+func user_location(settings UserSettings) *time.Location {
+	if settings.Timezone == "" {
+		return time.UTC
+	}
+	location, err := time.LoadLocation(settings.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+// in_quiet_hours reports whether now falls into the user's quiet hours. A
+// range may wrap around midnight.
+func in_quiet_hours(settings UserSettings, now time.Time) bool {
+	if settings.QuietStart == "" || settings.QuietEnd == "" {
+		return false
+	}
+	start, err := parse_clock(settings.QuietStart)
+	if err != nil {
+		return false
+	}
+	end, err := parse_clock(settings.QuietEnd)
+	if err != nil {
+		return false
+	}
+	local := now.In(user_location(settings))
+	minute := local.Hour()*60 + local.Minute()
+	if start < end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end
+}
+
+// notification_delivery decides how a notification reaches the user at now:
+// not at all while do-not-disturb is on or quiet hours mute it, without a
+// sound during silent quiet hours, normally otherwise.
+func notification_delivery(settings UserSettings, now time.Time) (deliver bool, silent bool) {
+	if now.Before(settings.DndUntil) {
+		return false, false
+	}
+	if in_quiet_hours(settings, now) {
+		return settings.QuietMode != quiet_mode_mute, true
+	}
+	return true, false
+}
+
+// new_notification builds the message for a subscriber notification, or
+// returns false if the user does not want to receive it right now.
+func new_notification(chat_id int64, text string, settings UserSettings, now time.Time) (tgbotapi.MessageConfig, bool) {
+	deliver, silent := notification_delivery(settings, now)
+	message := tgbotapi.NewMessage(chat_id, text)
+	message.DisableNotification = silent
+	return message, deliver
+}
+
+// describe_quiet_hours returns a human readable summary of the quiet hours
+// and do-not-disturb state of the user.
+func describe_quiet_hours(settings UserSettings, now time.Time) string {
+	var text string
+	if settings.QuietStart == "" {
+		text = "Quiet hours are off"
+	} else {
+		mode := "sent silently"
+		if settings.QuietMode == quiet_mode_mute {
+			mode = "muted"
+		}
+		text = fmt.Sprintf("Quiet hours: %s-%s %s, notifications are %s", settings.QuietStart, settings.QuietEnd, user_location(settings), mode)
+	}
+	if now.Before(settings.DndUntil) {
+		text += fmt.Sprintf("\nDo not disturb until %s", settings.DndUntil.In(user_location(settings)).Format("2006-01-02 15:04 MST"))
+	}
+	return text
+}
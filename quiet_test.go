@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNotificationDelivery(t *testing.T) {
+	warsaw, err := time.LoadLocation("Europe/Warsaw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 23:30 in Warsaw, 22:30 UTC.
+	now := time.Date(2024, 1, 15, 23, 30, 0, 0, warsaw)
+	night := UserSettings{QuietStart: "23:00", QuietEnd: "08:00", QuietMode: quiet_mode_silent, Timezone: "Europe/Warsaw"}
+	muted := night
+	muted.QuietMode = quiet_mode_mute
+	utc := night
+	utc.Timezone = ""
+	dnd := UserSettings{DndUntil: now.Add(time.Hour)}
+	expired := UserSettings{DndUntil: now.Add(-time.Hour)}
+
+	tests := []struct {
+		name     string
+		settings UserSettings
+		deliver  bool
+		silent   bool
+	}{
+		{"no settings", UserSettings{}, true, false},
+		{"quiet hours across midnight", night, true, true},
+		{"muted quiet hours", muted, false, true},
+		{"quiet hours in UTC", utc, true, false},
+		{"do not disturb", dnd, false, false},
+		{"expired do not disturb", expired, true, false},
+	}
+	for _, test := range tests {
+		deliver, silent := notification_delivery(test.settings, now)
+		if deliver != test.deliver || silent != test.silent {
+			t.Errorf("%s: got deliver=%v silent=%v, want deliver=%v silent=%v", test.name, deliver, silent, test.deliver, test.silent)
+		}
+	}
+}
+
+func TestParseQuietRange(t *testing.T) {
+	if start, end, err := parse_quiet_range("23:00-08:00"); err != nil || start != "23:00" || end != "08:00" {
+		t.Fatalf("got %q, %q, %v", start, end, err)
+	}
+	for _, value := range []string{"23:00", "25:00-08:00", "08:00-08:00"} {
+		if _, _, err := parse_quiet_range(value); err == nil {
+			t.Errorf("%q was accepted", value)
+		}
+	}
+}
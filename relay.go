@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/multiplay/go-ts3"
+)
+
+// Relay bridges text chat between one Teamspeak channel and one Telegram
+// group. A ServerQuery client only receives text messages of the channel it
+// is sitting in, so there can be at most one relay at a time.
+type Relay struct {
+	repository Store
+	teamspeak  TeamspeakClient
+	telegram   *tgbotapi.BotAPI
+
+	mutex   sync.Mutex
+	config  ChatRelay
+	active  bool
+	self_id string
+}
+
+func NewRelay(repository Store, teamspeak TeamspeakClient, telegram *tgbotapi.BotAPI) *Relay {
+	return &Relay{
+		repository: repository,
+		teamspeak:  teamspeak,
+		telegram:   telegram,
+	}
+}
+
+// Start loads the stored relay, if any, and joins its channel.
+func (relay *Relay) Start() error {
+	config, err := relay.repository.GetChatRelay()
+	if err == ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	relay.mutex.Lock()
+	relay.config = config
+	relay.active = true
+	relay.mutex.Unlock()
+	return relay.Join()
+}
+
+// Join moves the bot into the relayed channel and registers for its text
+// messages. It has to be repeated after every reconnect, since a new query
+// session starts in the default channel.
+func (relay *Relay) Join() error {
+	relay.mutex.Lock()
+	config, active := relay.config, relay.active
+	relay.mutex.Unlock()
+	if !active {
+		return nil
+	}
+	channel, err := findTeamspeakChannel(config.ChannelName, relay.teamspeak)
+	if err != nil {
+		return err
+	}
+	whoami, err := getTeamspeakWhoami(relay.teamspeak)
+	if err != nil {
+		return err
+	}
+	self_id := whoami.ClientId
+	if whoami.ChannelId != channel.Cid {
+		cmd := ts3.NewCmd("clientmove").WithArgs(
+			ts3.NewArg("clid", self_id),
+			ts3.NewArg("cid", channel.Cid),
+		)
+		if _, err := relay.teamspeak.ExecCmd(cmd); err != nil {
+			return err
+		}
+	}
+	if err := relay.teamspeak.Register(ts3.TextChannelEvents); err != nil {
+		return err
+	}
+	relay.mutex.Lock()
+	relay.self_id = self_id
+	relay.mutex.Unlock()
+	log.Println("Relaying Teamspeak channel", config.ChannelName, "to Telegram chat", config.TelegramChatId)
+	return nil
+}
+
+// Set binds the Teamspeak channel to the Telegram chat, replacing any
+// previous relay.
+func (relay *Relay) Set(telegram_chat_id int64, channel_name string) error {
+	if _, err := findTeamspeakChannel(channel_name, relay.teamspeak); err != nil {
+		return err
+	}
+	config := ChatRelay{Id: chat_relay_id, TelegramChatId: telegram_chat_id, ChannelName: channel_name}
+	if err := relay.repository.SetChatRelay(config); err != nil {
+		return err
+	}
+	relay.mutex.Lock()
+	relay.config = config
+	relay.active = true
+	relay.mutex.Unlock()
+	return relay.Join()
+}
+
+// Disable stops relaying. The bot stays in the channel until the next
+// reconnect, but messages are no longer forwarded.
+func (relay *Relay) Disable() error {
+	if err := relay.repository.RemoveChatRelay(); err != nil {
+		return err
+	}
+	relay.mutex.Lock()
+	relay.active = false
+	relay.mutex.Unlock()
+	return nil
+}
+
+// Current returns the active relay configuration.
+func (relay *Relay) Current() (ChatRelay, bool) {
+	relay.mutex.Lock()
+	defer relay.mutex.Unlock()
+	return relay.config, relay.active
+}
+
+// ToTeamspeak posts a Telegram message into the relayed channel.
+func (relay *Relay) ToTeamspeak(sender string, text string) error {
+	if _, active := relay.Current(); !active {
+		return errors.New("relay is not active")
+	}
+	// targetmode=2 sends to the channel the query client is currently in.
+	cmd := ts3.NewCmd("sendtextmessage").WithArgs(
+		ts3.NewArg("targetmode", 2),
+		ts3.NewArg("target", 0),
+		ts3.NewArg("msg", fmt.Sprintf("[b]%s:[/b] %s", sender, text)),
+	)
+	_, err := relay.teamspeak.ExecCmd(cmd)
+	return err
+}
+
+// FromTeamspeak forwards a textmessage notification to the Telegram chat.
+func (relay *Relay) FromTeamspeak(notification ts3.Notification) {
+	relay.mutex.Lock()
+	config, active, self_id := relay.config, relay.active, relay.self_id
+	relay.mutex.Unlock()
+	data := notification.Data
+	if !active || data["targetmode"] != "2" || data["invokerid"] == self_id {
+		return
+	}
+	text := fmt.Sprintf("%s: %s", data["invokername"], data["msg"])
+	if _, err := relay.telegram.Send(tgbotapi.NewMessage(config.TelegramChatId, text)); err != nil {
+		log.Println("Error relaying message to Telegram:", err)
+	}
+}
+
+// RelayLink forwards plain (non-command) messages of the relayed Telegram
+// chat to Teamspeak. Commands are passed on to the next link.
+type RelayLink struct {
+	relay *Relay
+}
+
+func (link RelayLink) Run(context *BotContext, next func()) {
+	message := context.update.Message
+	config, active := link.relay.Current()
+	if !active || message == nil || message.Chat.ID != config.TelegramChatId || strings.HasPrefix(message.Text, "/") {
+		next()
+		return
+	}
+	if message.Text == "" {
+		return
+	}
+	if err := link.relay.ToTeamspeak(telegram_display_name(message.From), message.Text); err != nil {
+		log.Println("Error relaying message to Teamspeak:", err)
+	}
+}
+func (link RelayLink) Name() string {
+	return "RelayLink"
+}
+
+func telegram_display_name(user *tgbotapi.User) string {
+	if user == nil {
+		return "Unknown"
+	}
+	name := strings.TrimSpace(user.FirstName + " " + user.LastName)
+	if name == "" {
+		name = user.UserName
+	}
+	return name
+}
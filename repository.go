@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -13,7 +14,24 @@ import (
 const database_name = "ts3bot"
 const whitelist_collection = "whitelist"
 const subscribers_collection = "subscribers"
+const quotes_collection = "quotes"
+const properties_collection = "properties"
+const relays_collection = "relays"
+const settings_collection = "settings"
+const digests_collection = "digests"
+const groups_collection = "groups"
+const sessions_collection = "sessions"
+const playtime_collection = "playtime"
+const peaks_collection = "peaks"
+const samples_collection = "samples"
+const links_collection = "links"
+const roles_collection = "roles"
+const permissions_collection = "permissions"
+const admins_collection = "admins"
+const access_requests_collection = "access_requests"
+const invites_collection = "invites"
 
+// Repository is the MongoDB implementation of Store.
 type Repository struct {
 	Client *mongo.Client
 }
@@ -96,6 +114,9 @@ func (repository *Repository) GetSubscribers(subscribed_teamspeak_id string) (Us
   collection := repository.Client.Database(database_name).Collection(subscribers_collection)
   var result UserSubscribers
   err := collection.FindOne(context.Background(), bson.M{"_id": subscribed_teamspeak_id}).Decode(&result)
+  if err == mongo.ErrNoDocuments {
+    return result, ErrNotFound
+  }
   return result, err
 }
 
@@ -112,6 +133,19 @@ func (repository *Repository) GetSubscribedTeamspeaks(subscriber_telegram_id int
   return results, nil
 }
 
+func (repository *Repository) GetAllSubscribers() ([]UserSubscribers, error) {
+  collection := repository.Client.Database(database_name).Collection(subscribers_collection)
+  cursor, err := collection.Find(context.Background(), bson.M{})
+  if err != nil {
+    return nil, err
+  }
+  var results []UserSubscribers
+  if err = cursor.All(context.Background(), &results); err != nil {
+    return nil, err
+  }
+  return results, nil
+}
+
 type Quote struct {
 	UUID      string `bson:"_id"`
 	Author    string `bson:"author"`
@@ -120,13 +154,13 @@ type Quote struct {
 }
 
 func (repository *Repository) AddQuote(quote Quote) error {
-	collection := repository.Client.Database(database_name).Collection("quotes")
+	collection := repository.Client.Database(database_name).Collection(quotes_collection)
 	_, err := collection.InsertOne(context.Background(), quote)
 	return err
 }
 
 func (repository *Repository) GetAllQuotes() ([]Quote, error) {
-	collection := repository.Client.Database(database_name).Collection("quotes")
+	collection := repository.Client.Database(database_name).Collection(quotes_collection)
 	cursor, err := collection.Find(context.Background(), bson.M{})
 	if err != nil {
 		return nil, err
@@ -140,7 +174,7 @@ func (repository *Repository) GetAllQuotes() ([]Quote, error) {
 }
 
 func (repository *Repository) DeleteQuote(uuid string) error {
-	collection := repository.Client.Database(database_name).Collection("quotes")
+	collection := repository.Client.Database(database_name).Collection(quotes_collection)
 	_, err := collection.DeleteOne(context.Background(), bson.M{"_id": uuid})
 	return err
 }
@@ -151,7 +185,7 @@ type Property struct {
 }
 
 func (repository *Repository) SetProperty(property Property) error {
-	collection := repository.Client.Database(database_name).Collection("properties")
+	collection := repository.Client.Database(database_name).Collection(properties_collection)
 	opts := options.Update().SetUpsert(true)
 	filter := bson.M{"_id": property.ID}
 	update := bson.M{"$set": property}
@@ -160,7 +194,7 @@ func (repository *Repository) SetProperty(property Property) error {
 }
 
 func (repository *Repository) GetProperty(id string, defaultValueFunc func() string) (Property, error) {
-	collection := repository.Client.Database(database_name).Collection("properties")
+	collection := repository.Client.Database(database_name).Collection(properties_collection)
 	var property Property
 	err := collection.FindOne(context.Background(), bson.M{"_id": id}).Decode(&property)
 	if err != nil {
@@ -172,6 +206,19 @@ func (repository *Repository) GetProperty(id string, defaultValueFunc func() str
 	return property, nil
 }
 
+func (repository *Repository) GetAllProperties() ([]Property, error) {
+	collection := repository.Client.Database(database_name).Collection(properties_collection)
+	cursor, err := collection.Find(context.Background(), bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var properties []Property
+	if err = cursor.All(context.Background(), &properties); err != nil {
+		return nil, err
+	}
+	return properties, nil
+}
+
 func (repository *Repository) SetQuotesChannel(value string) (error) {
   property := Property{ID: "quotes_channel", Value: value}
   err := repository.SetProperty(property)
@@ -185,3 +232,654 @@ func (repository *Repository) GetQuotesChannel() (string, bool) {
   }
   return property.Value, true
 }
+
+// chat_relay_id is the id of the single ChatRelay document; the query client
+// can only listen to one channel at a time.
+const chat_relay_id = "relay"
+
+type ChatRelay struct {
+	Id             string `bson:"_id"`
+	TelegramChatId int64  `bson:"telegram_chat_id"`
+	ChannelName    string `bson:"channel_name"`
+}
+
+func (repository *Repository) SetChatRelay(relay ChatRelay) error {
+	collection := repository.Client.Database(database_name).Collection(relays_collection)
+	relay.Id = chat_relay_id
+	_, err := collection.ReplaceOne(context.Background(), bson.M{"_id": chat_relay_id}, relay, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (repository *Repository) GetChatRelay() (ChatRelay, error) {
+	collection := repository.Client.Database(database_name).Collection(relays_collection)
+	var relay ChatRelay
+	err := collection.FindOne(context.Background(), bson.M{"_id": chat_relay_id}).Decode(&relay)
+	if err == mongo.ErrNoDocuments {
+		return relay, ErrNotFound
+	}
+	return relay, err
+}
+
+func (repository *Repository) RemoveChatRelay() error {
+	collection := repository.Client.Database(database_name).Collection(relays_collection)
+	_, err := collection.DeleteOne(context.Background(), bson.M{"_id": chat_relay_id})
+	return err
+}
+
+// UserSettings holds the notification preferences of a Telegram user.
+type UserSettings struct {
+	Id                 string `bson:"_id"`
+	NotifyMoves        bool   `bson:"notify_moves"`
+	ConnectTemplate    string `bson:"connect_template,omitempty"`
+	DisconnectTemplate string    `bson:"disconnect_template,omitempty"`
+	QuietStart         string    `bson:"quiet_start,omitempty"`
+	QuietEnd           string    `bson:"quiet_end,omitempty"`
+	QuietMode          string    `bson:"quiet_mode,omitempty"`
+	Timezone           string    `bson:"timezone,omitempty"`
+	DndUntil           time.Time `bson:"dnd_until,omitempty"`
+	DigestMode         string    `bson:"digest_mode,omitempty"`
+}
+
+func (repository *Repository) GetUserSettings(telegram_id int64) (UserSettings, error) {
+	collection := repository.Client.Database(database_name).Collection(settings_collection)
+	settings := UserSettings{Id: fmt.Sprintf("%d", telegram_id)}
+	err := collection.FindOne(context.Background(), bson.M{"_id": settings.Id}).Decode(&settings)
+	if err == mongo.ErrNoDocuments {
+		return settings, nil
+	}
+	return settings, err
+}
+
+func (repository *Repository) SetUserSettings(settings UserSettings) error {
+	collection := repository.Client.Database(database_name).Collection(settings_collection)
+	_, err := collection.ReplaceOne(context.Background(), bson.M{"_id": settings.Id}, settings, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (repository *Repository) GetAllUserSettings() ([]UserSettings, error) {
+	collection := repository.Client.Database(database_name).Collection(settings_collection)
+	cursor, err := collection.Find(context.Background(), bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var results []UserSettings
+	if err = cursor.All(context.Background(), &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// DigestEvent is a connect or disconnect waiting to be delivered in a digest.
+type DigestEvent struct {
+	Kind   string    `bson:"kind"`
+	TsId   string    `bson:"ts_id"`
+	Name   string    `bson:"name"`
+	Reason string    `bson:"reason,omitempty"`
+	Time   time.Time `bson:"time"`
+}
+
+// PendingDigest collects the events of a Telegram user in digest mode since
+// the last digest was sent.
+type PendingDigest struct {
+	Id     string        `bson:"_id"`
+	Since  time.Time     `bson:"since"`
+	Events []DigestEvent `bson:"events"`
+}
+
+func (repository *Repository) AddDigestEvent(telegram_id int64, event DigestEvent) error {
+	collection := repository.Client.Database(database_name).Collection(digests_collection)
+	update := bson.M{
+		"$push":        bson.M{"events": event},
+		"$setOnInsert": bson.M{"since": event.Time},
+	}
+	_, err := collection.UpdateOne(context.Background(), bson.M{"_id": fmt.Sprintf("%d", telegram_id)}, update, options.Update().SetUpsert(true))
+	return err
+}
+
+func (repository *Repository) GetPendingDigests() ([]PendingDigest, error) {
+	collection := repository.Client.Database(database_name).Collection(digests_collection)
+	cursor, err := collection.Find(context.Background(), bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var results []PendingDigest
+	if err = cursor.All(context.Background(), &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (repository *Repository) SetPendingDigest(digest PendingDigest) error {
+	collection := repository.Client.Database(database_name).Collection(digests_collection)
+	_, err := collection.ReplaceOne(context.Background(), bson.M{"_id": digest.Id}, digest, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (repository *Repository) TakePendingDigest(telegram_id int64) (PendingDigest, error) {
+	collection := repository.Client.Database(database_name).Collection(digests_collection)
+	var digest PendingDigest
+	err := collection.FindOneAndDelete(context.Background(), bson.M{"_id": fmt.Sprintf("%d", telegram_id)}).Decode(&digest)
+	if err == mongo.ErrNoDocuments {
+		return digest, ErrNotFound
+	}
+	return digest, err
+}
+
+// GroupBinding subscribes a Telegram chat to the server as a whole. Notified
+// remembers whether the chat was told the server reached Threshold, so the
+// next message is sent once it is empty again.
+type GroupBinding struct {
+	Id        string `bson:"_id"`
+	ChatId    int64  `bson:"chat_id"`
+	Title     string `bson:"title"`
+	Threshold int    `bson:"threshold"`
+	Notified  bool   `bson:"notified"`
+}
+
+func (repository *Repository) SetGroupBinding(binding GroupBinding) error {
+	collection := repository.Client.Database(database_name).Collection(groups_collection)
+	binding.Id = fmt.Sprintf("%d", binding.ChatId)
+	_, err := collection.ReplaceOne(context.Background(), bson.M{"_id": binding.Id}, binding, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (repository *Repository) RemoveGroupBinding(chat_id int64) error {
+	collection := repository.Client.Database(database_name).Collection(groups_collection)
+	result, err := collection.DeleteOne(context.Background(), bson.M{"_id": fmt.Sprintf("%d", chat_id)})
+	if err == nil && result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (repository *Repository) GetGroupBindings() ([]GroupBinding, error) {
+	collection := repository.Client.Database(database_name).Collection(groups_collection)
+	cursor, err := collection.Find(context.Background(), bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var results []GroupBinding
+	if err = cursor.All(context.Background(), &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Session is one stay of a Teamspeak user on the server. Open sessions have
+// not ended yet.
+type Session struct {
+	Id       string    `bson:"_id"`
+	TsId     string    `bson:"ts_id"`
+	Nickname string    `bson:"nickname"`
+	Channel  string    `bson:"channel"`
+	Start    time.Time `bson:"start"`
+	End      time.Time `bson:"end,omitempty"`
+	Reason   string    `bson:"reason,omitempty"`
+	Open     bool      `bson:"open"`
+}
+
+func (repository *Repository) StartSession(session Session) error {
+	collection := repository.Client.Database(database_name).Collection(sessions_collection)
+	session.Open = true
+	_, err := collection.InsertOne(context.Background(), session)
+	return err
+}
+
+func (repository *Repository) SetSession(session Session) error {
+	collection := repository.Client.Database(database_name).Collection(sessions_collection)
+	_, err := collection.ReplaceOne(context.Background(), bson.M{"_id": session.Id}, session, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (repository *Repository) EndSession(ts_id string, end time.Time, reason string) error {
+	collection := repository.Client.Database(database_name).Collection(sessions_collection)
+	update := bson.M{"$set": bson.M{"end": end, "reason": reason, "open": false}}
+	_, err := collection.UpdateMany(context.Background(), bson.M{"ts_id": ts_id, "open": true}, update)
+	return err
+}
+
+func (repository *Repository) GetOpenSessions() ([]Session, error) {
+	return repository.findSessions(bson.M{"open": true})
+}
+
+func (repository *Repository) GetSessions(ts_id string, since time.Time) ([]Session, error) {
+	return repository.findSessions(bson.M{
+		"ts_id": ts_id,
+		"$or":   bson.A{bson.M{"open": true}, bson.M{"end": bson.M{"$gte": since}}},
+	})
+}
+
+func (repository *Repository) GetAllSessions() ([]Session, error) {
+	return repository.findSessions(bson.M{})
+}
+
+func (repository *Repository) GetLastSession(ts_id string) (Session, error) {
+	collection := repository.Client.Database(database_name).Collection(sessions_collection)
+	var session Session
+	opts := options.FindOne().SetSort(bson.M{"start": -1})
+	err := collection.FindOne(context.Background(), bson.M{"ts_id": ts_id}, opts).Decode(&session)
+	if err == mongo.ErrNoDocuments {
+		return session, ErrNotFound
+	}
+	return session, err
+}
+
+func (repository *Repository) findSessions(filter bson.M) ([]Session, error) {
+	collection := repository.Client.Database(database_name).Collection(sessions_collection)
+	cursor, err := collection.Find(context.Background(), filter, options.Find().SetSort(bson.M{"start": -1}))
+	if err != nil {
+		return nil, err
+	}
+	var results []Session
+	if err = cursor.All(context.Background(), &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// PlaytimeCounter is the online time of a Teamspeak user on one UTC day, in
+// total and per UTC hour of the day ("0" to "23").
+type PlaytimeCounter struct {
+	Id       string           `bson:"_id"`
+	TsId     string           `bson:"ts_id"`
+	Nickname string           `bson:"nickname"`
+	Day      string           `bson:"day"`
+	Seconds  int64            `bson:"seconds"`
+	Hours    map[string]int64 `bson:"hours"`
+}
+
+// DailyPeak is the highest number of users online at once on a UTC day.
+type DailyPeak struct {
+	Day  string    `bson:"_id"`
+	Peak int       `bson:"peak"`
+	Time time.Time `bson:"time"`
+}
+
+func playtime_counter_id(ts_id string, day string) string {
+	return ts_id + "/" + day
+}
+
+func (repository *Repository) AddPlaytime(counter PlaytimeCounter) error {
+	collection := repository.Client.Database(database_name).Collection(playtime_collection)
+	increments := bson.M{"seconds": counter.Seconds}
+	for hour, seconds := range counter.Hours {
+		increments["hours."+hour] = seconds
+	}
+	update := bson.M{
+		"$inc": increments,
+		"$set": bson.M{"ts_id": counter.TsId, "nickname": counter.Nickname, "day": counter.Day},
+	}
+	id := playtime_counter_id(counter.TsId, counter.Day)
+	_, err := collection.UpdateOne(context.Background(), bson.M{"_id": id}, update, options.Update().SetUpsert(true))
+	return err
+}
+
+func (repository *Repository) GetPlaytime(since_day string) ([]PlaytimeCounter, error) {
+	collection := repository.Client.Database(database_name).Collection(playtime_collection)
+	cursor, err := collection.Find(context.Background(), bson.M{"day": bson.M{"$gte": since_day}})
+	if err != nil {
+		return nil, err
+	}
+	var results []PlaytimeCounter
+	if err = cursor.All(context.Background(), &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (repository *Repository) RecordPeak(peak DailyPeak) error {
+	collection := repository.Client.Database(database_name).Collection(peaks_collection)
+	filter := bson.M{"_id": peak.Day, "peak": bson.M{"$lt": peak.Peak}}
+	update := bson.M{"$set": bson.M{"peak": peak.Peak, "time": peak.Time}}
+	_, err := collection.UpdateOne(context.Background(), filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// The stored peak of the day is already higher.
+		return nil
+	}
+	return err
+}
+
+func (repository *Repository) GetPeaks(since_day string) ([]DailyPeak, error) {
+	collection := repository.Client.Database(database_name).Collection(peaks_collection)
+	cursor, err := collection.Find(context.Background(), bson.M{"_id": bson.M{"$gte": since_day}})
+	if err != nil {
+		return nil, err
+	}
+	var results []DailyPeak
+	if err = cursor.All(context.Background(), &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// ActivitySample is the number of users online at a point in time.
+type ActivitySample struct {
+	Time  time.Time `bson:"_id"`
+	Count int       `bson:"count"`
+}
+
+func (repository *Repository) AddActivitySample(sample ActivitySample) error {
+	collection := repository.Client.Database(database_name).Collection(samples_collection)
+	_, err := collection.InsertOne(context.Background(), sample)
+	return err
+}
+
+func (repository *Repository) GetActivitySamples(since time.Time) ([]ActivitySample, error) {
+	collection := repository.Client.Database(database_name).Collection(samples_collection)
+	opts := options.Find().SetSort(bson.M{"_id": 1})
+	cursor, err := collection.Find(context.Background(), bson.M{"_id": bson.M{"$gte": since}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var results []ActivitySample
+	if err = cursor.All(context.Background(), &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// LinkedIdentity ties a Telegram user to a Teamspeak identity. Each side can
+// be linked at most once.
+type LinkedIdentity struct {
+	Id         string    `bson:"_id"`
+	TelegramId int64     `bson:"telegram_id"`
+	TsId       string    `bson:"ts_id"`
+	Nickname   string    `bson:"nickname"`
+	LinkedAt   time.Time `bson:"linked_at"`
+}
+
+func (repository *Repository) SetLinkedIdentity(identity LinkedIdentity) error {
+	collection := repository.Client.Database(database_name).Collection(links_collection)
+	identity.Id = fmt.Sprintf("%d", identity.TelegramId)
+	// A Teamspeak identity can only belong to one Telegram user.
+	_, err := collection.DeleteMany(context.Background(), bson.M{"ts_id": identity.TsId, "_id": bson.M{"$ne": identity.Id}})
+	if err != nil {
+		return err
+	}
+	_, err = collection.ReplaceOne(context.Background(), bson.M{"_id": identity.Id}, identity, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (repository *Repository) GetLinkedIdentity(telegram_id int64) (LinkedIdentity, error) {
+	return repository.findLinkedIdentity(bson.M{"_id": fmt.Sprintf("%d", telegram_id)})
+}
+
+func (repository *Repository) GetLinkedIdentityByTsId(ts_id string) (LinkedIdentity, error) {
+	return repository.findLinkedIdentity(bson.M{"ts_id": ts_id})
+}
+
+func (repository *Repository) findLinkedIdentity(filter bson.M) (LinkedIdentity, error) {
+	collection := repository.Client.Database(database_name).Collection(links_collection)
+	var identity LinkedIdentity
+	err := collection.FindOne(context.Background(), filter).Decode(&identity)
+	if err == mongo.ErrNoDocuments {
+		return identity, ErrNotFound
+	}
+	return identity, err
+}
+
+func (repository *Repository) RemoveLinkedIdentity(telegram_id int64) error {
+	collection := repository.Client.Database(database_name).Collection(links_collection)
+	result, err := collection.DeleteOne(context.Background(), bson.M{"_id": fmt.Sprintf("%d", telegram_id)})
+	if err == nil && result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (repository *Repository) GetAllLinkedIdentities() ([]LinkedIdentity, error) {
+	collection := repository.Client.Database(database_name).Collection(links_collection)
+	cursor, err := collection.Find(context.Background(), bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var results []LinkedIdentity
+	if err = cursor.All(context.Background(), &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// UserRole is a role assigned to a Telegram user with /role assign. Users
+// without one get their role from the config admins and the whitelist.
+type UserRole struct {
+	Id         string `bson:"_id"`
+	TelegramId int64  `bson:"telegram_id"`
+	Role       string `bson:"role"`
+}
+
+func (repository *Repository) SetUserRole(telegram_id int64, role string) error {
+	collection := repository.Client.Database(database_name).Collection(roles_collection)
+	entry := UserRole{Id: fmt.Sprintf("%d", telegram_id), TelegramId: telegram_id, Role: role}
+	_, err := collection.ReplaceOne(context.Background(), bson.M{"_id": entry.Id}, entry, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (repository *Repository) GetUserRole(telegram_id int64) (UserRole, error) {
+	collection := repository.Client.Database(database_name).Collection(roles_collection)
+	var entry UserRole
+	err := collection.FindOne(context.Background(), bson.M{"_id": fmt.Sprintf("%d", telegram_id)}).Decode(&entry)
+	if err == mongo.ErrNoDocuments {
+		return entry, ErrNotFound
+	}
+	return entry, err
+}
+
+func (repository *Repository) RemoveUserRole(telegram_id int64) error {
+	collection := repository.Client.Database(database_name).Collection(roles_collection)
+	result, err := collection.DeleteOne(context.Background(), bson.M{"_id": fmt.Sprintf("%d", telegram_id)})
+	if err == nil && result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (repository *Repository) GetUserRoles() ([]UserRole, error) {
+	collection := repository.Client.Database(database_name).Collection(roles_collection)
+	cursor, err := collection.Find(context.Background(), bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var results []UserRole
+	if err = cursor.All(context.Background(), &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// CommandPermission lists the roles granted a command on top of the roles
+// allowed by its default.
+type CommandPermission struct {
+	Command string   `bson:"_id"`
+	Roles   []string `bson:"roles"`
+}
+
+func (repository *Repository) GrantPermission(command string, role string) error {
+	collection := repository.Client.Database(database_name).Collection(permissions_collection)
+	_, err := collection.UpdateOne(context.Background(), bson.M{"_id": command}, bson.M{"$addToSet": bson.M{"roles": role}}, options.Update().SetUpsert(true))
+	return err
+}
+
+func (repository *Repository) RevokePermission(command string, role string) error {
+	collection := repository.Client.Database(database_name).Collection(permissions_collection)
+	result, err := collection.UpdateOne(context.Background(), bson.M{"_id": command}, bson.M{"$pull": bson.M{"roles": role}})
+	if err == nil && result.ModifiedCount == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (repository *Repository) GetPermission(command string) (CommandPermission, error) {
+	collection := repository.Client.Database(database_name).Collection(permissions_collection)
+	var permission CommandPermission
+	err := collection.FindOne(context.Background(), bson.M{"_id": command}).Decode(&permission)
+	if err == mongo.ErrNoDocuments {
+		return permission, ErrNotFound
+	}
+	return permission, err
+}
+
+func (repository *Repository) GetPermissions() ([]CommandPermission, error) {
+	collection := repository.Client.Database(database_name).Collection(permissions_collection)
+	cursor, err := collection.Find(context.Background(), bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var results []CommandPermission
+	if err = cursor.All(context.Background(), &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// AdminEntry is an admin added at runtime with /admin add, on top of the
+// admins listed in the config.
+type AdminEntry struct {
+	Id         string    `bson:"_id"`
+	TelegramId int64     `bson:"telegram_id"`
+	Alias      string    `bson:"alias"`
+	AddedBy    int64     `bson:"added_by"`
+	AddedAt    time.Time `bson:"added_at"`
+}
+
+func (repository *Repository) AddAdmin(admin AdminEntry) error {
+	collection := repository.Client.Database(database_name).Collection(admins_collection)
+	admin.Id = fmt.Sprintf("%d", admin.TelegramId)
+	_, err := collection.ReplaceOne(context.Background(), bson.M{"_id": admin.Id}, admin, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (repository *Repository) RemoveAdmin(telegram_id int64) error {
+	collection := repository.Client.Database(database_name).Collection(admins_collection)
+	result, err := collection.DeleteOne(context.Background(), bson.M{"_id": fmt.Sprintf("%d", telegram_id)})
+	if err == nil && result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (repository *Repository) IsAdmin(telegram_id int64) (bool, error) {
+	collection := repository.Client.Database(database_name).Collection(admins_collection)
+	count, err := collection.CountDocuments(context.Background(), bson.M{"_id": fmt.Sprintf("%d", telegram_id)})
+	return count > 0, err
+}
+
+func (repository *Repository) GetAdmins() ([]AdminEntry, error) {
+	collection := repository.Client.Database(database_name).Collection(admins_collection)
+	cursor, err := collection.Find(context.Background(), bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var results []AdminEntry
+	if err = cursor.All(context.Background(), &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// AccessRequest is a pending /request_access of a user, kept until an admin
+// decides on it so that repeating the command does not message the admins
+// again.
+type AccessRequest struct {
+	Id          string    `bson:"_id"`
+	TelegramId  int64     `bson:"telegram_id"`
+	RequestedAt time.Time `bson:"requested_at"`
+}
+
+func (repository *Repository) AddAccessRequest(request AccessRequest, since time.Time) error {
+	collection := repository.Client.Database(database_name).Collection(access_requests_collection)
+	request.Id = fmt.Sprintf("%d", request.TelegramId)
+	// A newer request does not match the filter, so the upsert tries to
+	// insert a second document with the same id.
+	filter := bson.M{"_id": request.Id, "requested_at": bson.M{"$lt": since}}
+	_, err := collection.ReplaceOne(context.Background(), filter, request, options.Replace().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrAccessRequestPending
+	}
+	return err
+}
+
+func (repository *Repository) RemoveAccessRequest(telegram_id int64) error {
+	collection := repository.Client.Database(database_name).Collection(access_requests_collection)
+	result, err := collection.DeleteOne(context.Background(), bson.M{"_id": fmt.Sprintf("%d", telegram_id)})
+	if err == nil && result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (repository *Repository) GetAccessRequests() ([]AccessRequest, error) {
+	collection := repository.Client.Database(database_name).Collection(access_requests_collection)
+	cursor, err := collection.Find(context.Background(), bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var results []AccessRequest
+	if err = cursor.All(context.Background(), &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Invite is a token handed out in a /start deep link that adds whoever opens
+// it to the whitelist, until it expires or has been used MaxUses times.
+type Invite struct {
+	Token     string    `bson:"_id"`
+	CreatedBy int64     `bson:"created_by"`
+	CreatedAt time.Time `bson:"created_at"`
+	ExpiresAt time.Time `bson:"expires_at"`
+	MaxUses   int       `bson:"max_uses"`
+	Uses      int       `bson:"uses"`
+}
+
+func (repository *Repository) AddInvite(invite Invite) error {
+	collection := repository.Client.Database(database_name).Collection(invites_collection)
+	_, err := collection.InsertOne(context.Background(), invite)
+	return err
+}
+
+func (repository *Repository) UseInvite(token string, now time.Time) (Invite, error) {
+	collection := repository.Client.Database(database_name).Collection(invites_collection)
+	filter := bson.M{
+		"_id":        token,
+		"expires_at": bson.M{"$gt": now},
+		"$expr":      bson.M{"$lt": bson.A{"$uses", "$max_uses"}},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var invite Invite
+	err := collection.FindOneAndUpdate(context.Background(), filter, bson.M{"$inc": bson.M{"uses": 1}}, opts).Decode(&invite)
+	if err != mongo.ErrNoDocuments {
+		return invite, err
+	}
+	// Tell a token that never existed from one that can no longer be used.
+	count, err := collection.CountDocuments(context.Background(), bson.M{"_id": token})
+	if err != nil {
+		return invite, err
+	}
+	if count == 0 {
+		return invite, ErrNotFound
+	}
+	return invite, ErrInviteUnusable
+}
+
+func (repository *Repository) RemoveInvite(token string) error {
+	collection := repository.Client.Database(database_name).Collection(invites_collection)
+	result, err := collection.DeleteOne(context.Background(), bson.M{"_id": token})
+	if err == nil && result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (repository *Repository) GetInvites() ([]Invite, error) {
+	collection := repository.Client.Database(database_name).Collection(invites_collection)
+	cursor, err := collection.Find(context.Background(), bson.M{}, options.Find().SetSort(bson.M{"created_at": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var results []Invite
+	if err = cursor.All(context.Background(), &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"log"
+	"strings"
+)
+
+// Role is the permission level of a Telegram user. Each role includes the
+// permissions of the roles below it.
+type Role int
+
+const (
+	role_guest Role = iota
+	role_member
+	role_moderator
+	role_owner
+)
+
+var role_names = []string{"guest", "member", "moderator", "owner"}
+
+func (role Role) String() string {
+	return role_names[role]
+}
+
+func parse_role(name string) (Role, bool) {
+	for i, role_name := range role_names {
+		if strings.EqualFold(name, role_name) {
+			return Role(i), true
+		}
+	}
+	return role_guest, false
+}
+
+// Role returns the role of the sender. Admins, from the config or added with
+// /admin add, are owners; otherwise an assigned role wins over the whitelist,
+// which makes users members. The role is looked up once per update.
+func (context *BotContext) Role() Role {
+	if context.role == nil {
+		role := context.lookup_role()
+		context.role = &role
+	}
+	return *context.role
+}
+
+func (context *BotContext) lookup_role() Role {
+	if context.IsAdmin() {
+		return role_owner
+	}
+	id := context.GetUserID()
+	assigned, err := context.repository.GetUserRole(id)
+	if err == nil {
+		if role, ok := parse_role(assigned.Role); ok {
+			return role
+		}
+		log.Println("Unknown role", assigned.Role, "assigned to", id)
+	} else if err != ErrNotFound {
+		log.Println(err)
+	}
+	is_on_whitelist, err := context.repository.IsOnWhitelist(id)
+	if err != nil {
+		log.Println(err)
+	}
+	if is_on_whitelist {
+		return role_member
+	}
+	return role_guest
+}
+
+// required_role returns the lowest role allowed to use the command: its
+// default, lowered by the roles granted with /role grant.
+func required_role(handler CommandHandler, granted []string) Role {
+	required := handler.DefaultRole()
+	for _, name := range granted {
+		if role, ok := parse_role(name); ok && role < required {
+			required = role
+		}
+	}
+	return required
+}
+
+// granted_roles returns the roles granted the command.
+func granted_roles(repository Store, command string) []string {
+	permission, err := repository.GetPermission(command)
+	if err != nil {
+		if err != ErrNotFound {
+			log.Println(err)
+		}
+		return nil
+	}
+	return permission.Roles
+}
+
+// all_granted_roles returns the granted roles of every command, for listing
+// many commands without a query each.
+func all_granted_roles(repository Store) map[string][]string {
+	granted := make(map[string][]string)
+	permissions, err := repository.GetPermissions()
+	if err != nil {
+		log.Println(err)
+		return granted
+	}
+	for _, permission := range permissions {
+		granted[permission.Command] = permission.Roles
+	}
+	return granted
+}
+
+// CanUse reports whether the sender may use the command.
+func (context *BotContext) CanUse(handler CommandHandler) bool {
+	return context.Role() >= required_role(handler, granted_roles(context.repository, handler.Command()))
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// countingStore counts the permission and role lookups made through it.
+type countingStore struct {
+	Store
+	lookups map[string]int
+}
+
+func (store *countingStore) GetUserRole(telegram_id int64) (UserRole, error) {
+	store.lookups["GetUserRole"]++
+	return store.Store.GetUserRole(telegram_id)
+}
+func (store *countingStore) IsOnWhitelist(telegram_id int64) (bool, error) {
+	store.lookups["IsOnWhitelist"]++
+	return store.Store.IsOnWhitelist(telegram_id)
+}
+func (store *countingStore) GetPermission(command string) (CommandPermission, error) {
+	store.lookups["GetPermission"]++
+	return store.Store.GetPermission(command)
+}
+func (store *countingStore) GetPermissions() ([]CommandPermission, error) {
+	store.lookups["GetPermissions"]++
+	return store.Store.GetPermissions()
+}
+
+func TestRoleCommand(t *testing.T) {
+	harness := newCommandHarness(t)
+	fake := harness.fake
+	harness.repository.AddQuote(Quote{UUID: "a", Author: "Alice", Content: "to be or not"})
+
+	harness.send(member_id, "/role list")
+	fake.expect_message(t, member_id, "You are not allowed to use this command")
+	harness.send(member_id, "/deletequote a")
+	fake.expect_message(t, member_id, "You are not allowed to use this command")
+
+	harness.send(admin_id, "/role assign 2 moderator")
+	fake.expect_message(t, admin_id, "2 is now a moderator")
+	harness.send(admin_id, "/role assign 2 owner")
+	fake.expect_message(t, admin_id, "Owners are admins, use /admin add")
+	harness.send(member_id, "/deletequote a")
+	fake.expect_message(t, member_id, `Delete "to be or not" by Alice?`)
+
+	harness.send(admin_id, "/role grant deletequote moderator")
+	fake.expect_message(t, admin_id, "/deletequote is always allowed for moderator and above")
+	harness.send(admin_id, "/role grant setquotechannel moderator")
+	fake.expect_message(t, admin_id, "Granted /setquotechannel to moderator")
+	harness.send(member_id, "/setquotechannel Lobby")
+	fake.expect_message(t, member_id, "Quotes channel set to Lobby")
+
+	harness.send(admin_id, "/role list")
+	request := fake.next(t)
+	for _, want := range []string{"2 - moderator\n", "/deletequote - moderator\n", "/setquotechannel - moderator (default owner)\n", "/whitelist - owner\n"} {
+		if !strings.Contains(request.Text, want) {
+			t.Fatalf("%q missing from %q", want, request.Text)
+		}
+	}
+
+	harness.send(admin_id, "/role revoke setquotechannel moderator")
+	fake.expect_message(t, admin_id, "Revoked /setquotechannel from moderator")
+	harness.send(admin_id, "/role unassign 2")
+	fake.expect_message(t, admin_id, "Removed the role of 2")
+	harness.send(member_id, "/deletequote a")
+	fake.expect_message(t, member_id, "You are not allowed to use this command")
+}
+
+func TestHelpLooksUpPermissionsOnce(t *testing.T) {
+	harness := newCommandHarness(t)
+	store := &countingStore{Store: harness.repository, lookups: make(map[string]int)}
+	harness.context.repository = store
+
+	harness.send(member_id, "/help")
+	request := harness.fake.next(t)
+	if !strings.Contains(request.Text, "/subscribe") || strings.Contains(request.Text, "/whitelist") {
+		t.Fatalf("unexpected help %q", request.Text)
+	}
+	// One lookup for /help itself, then one for all commands.
+	want := map[string]int{"GetUserRole": 1, "IsOnWhitelist": 1, "GetPermission": 1, "GetPermissions": 1}
+	for name, count := range want {
+		if store.lookups[name] != count {
+			t.Errorf("%s called %d times, want %d", name, store.lookups[name], count)
+		}
+	}
+}
+
+func TestAdminCommand(t *testing.T) {
+	harness := newCommandHarness(t)
+	fake := harness.fake
+
+	harness.send(member_id, "/admin add 5")
+	fake.expect_message(t, member_id, "You are not allowed to use this command")
+	harness.send(admin_id, "/admin add 1")
+	fake.expect_message(t, admin_id, "1 is an admin in the config")
+	harness.send(admin_id, "/admin add 2 member")
+	fake.expect_message(t, admin_id, "Added 2 as an admin")
+
+	// Added admins are owners, but cannot manage admins.
+	harness.send(member_id, "/whitelist list")
+	fake.expect_message(t, member_id, "Whitelisted users:\nmember - 2\n")
+	harness.send(member_id, "/admin remove 2")
+	fake.expect_message(t, member_id, "Only admins from the config can manage admins")
+	harness.send(member_id, "/admin list")
+	request := fake.next(t)
+	if !strings.HasPrefix(request.Text, "Admins from the config:\n1\n\nAdded admins:\nmember - 2, added by 1 on ") {
+		t.Fatalf("unexpected admins %q", request.Text)
+	}
+
+	harness.send(admin_id, "/admin remove 2")
+	fake.expect_message(t, admin_id, "Removed 2 from the admins")
+	harness.send(admin_id, "/admin remove 2")
+	fake.expect_message(t, admin_id, "2 is not an admin")
+	harness.send(member_id, "/whitelist list")
+	fake.expect_message(t, member_id, "You are not allowed to use this command")
+}
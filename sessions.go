@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const default_history_days = 7
+const max_history_sessions = 20
+
+// start_session records that user connected at the given time.
+func start_session(user TeamspeakUser, at time.Time, notifications_context *NotificationsContext) {
+	channel := user.Cid
+	if found, err := getTeamspeakChannelById(user.Cid, notifications_context.teamspeak); err == nil {
+		channel = found.Name
+	}
+	session := Session{
+		Id:       uuid.New().String(),
+		TsId:     user.TsId,
+		Nickname: user.Nickname,
+		Channel:  channel,
+		Start:    at,
+	}
+	if err := notifications_context.repository.StartSession(session); err != nil {
+		log.Println("Error starting session:", err)
+	}
+}
+
+// end_session closes the open sessions of the user and adds them to the
+// playtime counters.
+func end_session(ts_id string, at time.Time, reason string, repository Store) {
+	open, err := repository.GetOpenSessions()
+	if err != nil {
+		log.Println("Error getting open sessions:", err)
+		return
+	}
+	for _, session := range open {
+		if session.TsId == ts_id {
+			record_playtime(session, at, repository)
+		}
+	}
+	if err := repository.EndSession(ts_id, at, reason); err != nil {
+		log.Println("Error ending session:", err)
+	}
+}
+
+// sync_sessions makes the open sessions match the presence state. Users who
+// are still online keep their session. The sessions of users who are gone
+// are closed at last_seen, the last time they are known to have been online,
+// and users online without a session get a new one.
+func sync_sessions(presence *Presence, last_seen time.Time, notifications_context *NotificationsContext) {
+	repository := notifications_context.repository
+	open, err := repository.GetOpenSessions()
+	if err != nil {
+		log.Println("Error getting open sessions:", err)
+		return
+	}
+	online := make(map[string]TeamspeakUser)
+	for _, user := range presence.Users() {
+		online[user.TsId] = user
+	}
+	tracked := make(map[string]bool)
+	for _, session := range open {
+		if _, ok := online[session.TsId]; ok {
+			tracked[session.TsId] = true
+			continue
+		}
+		end := last_seen
+		if end.Before(session.Start) {
+			end = session.Start
+		}
+		end_session(session.TsId, end, "", repository)
+	}
+	now := time.Now()
+	for ts_id, user := range online {
+		if !tracked[ts_id] {
+			start_session(user, now, notifications_context)
+		}
+	}
+	record_peak(presence, repository)
+}
+
+// last_activity returns the time of the last activity sample taken after
+// the oldest open session started, which is the last time a previous run
+// was known to be connected. It is zero if there is none.
+func last_activity(repository Store) time.Time {
+	open, err := repository.GetOpenSessions()
+	if err != nil || len(open) == 0 {
+		return time.Time{}
+	}
+	since := open[0].Start
+	for _, session := range open {
+		if session.Start.Before(since) {
+			since = session.Start
+		}
+	}
+	samples, err := repository.GetActivitySamples(since)
+	if err != nil {
+		log.Println("Error getting activity samples:", err)
+		return time.Time{}
+	}
+	var last time.Time
+	for _, sample := range samples {
+		if sample.Time.After(last) {
+			last = sample.Time
+		}
+	}
+	return last
+}
+
+// find_users_by_name returns the users whose nickname equals name, ignoring
+// case, or failing that the users whose nickname contains it.
+func find_users_by_name(name string, users []TeamspeakUser) []TeamspeakUser {
+	name = strings.ToLower(name)
+	var exact, partial []TeamspeakUser
+	for _, user := range users {
+		nickname := strings.ToLower(user.Nickname)
+		if nickname == name {
+			exact = append(exact, user)
+		} else if strings.Contains(nickname, name) {
+			partial = append(partial, user)
+		}
+	}
+	if len(exact) > 0 {
+		return exact
+	}
+	return partial
+}
+
+func format_session(session Session, location *time.Location) string {
+	start := session.Start.In(location).Format("2006-01-02 15:04")
+	var text string
+	if session.Open {
+		text = fmt.Sprintf("%s - now (%s)", start, format_duration(time.Since(session.Start)))
+	} else {
+		text = fmt.Sprintf("%s - %s (%s)", start, session.End.In(location).Format("15:04"), format_duration(session.End.Sub(session.Start)))
+	}
+	if session.Channel != "" {
+		text += " in " + session.Channel
+	}
+	if session.Reason != "" {
+		text += ", " + session.Reason
+	}
+	return text
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func playtime_seconds(t *testing.T, repository Store) map[string]int64 {
+	t.Helper()
+	counters, err := repository.GetPlaytime("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seconds := make(map[string]int64)
+	for _, counter := range counters {
+		seconds[counter.TsId] += counter.Seconds
+	}
+	return seconds
+}
+
+func TestSyncSessions(t *testing.T) {
+	_, teamspeak := newFakeTeamspeak(t)
+	repository := NewMemoryStore()
+	now := time.Now().Truncate(time.Second)
+	last_seen := now.Add(-time.Hour)
+	repository.StartSession(Session{Id: "a", TsId: "10", Nickname: "Alice", Start: now.Add(-2 * time.Hour)})
+	repository.StartSession(Session{Id: "b", TsId: "11", Nickname: "Bob", Start: now.Add(-2 * time.Hour)})
+	repository.StartSession(Session{Id: "c", TsId: "12", Nickname: "Carol", Start: now.Add(-30 * time.Minute)})
+	presence := NewPresence([]TeamspeakUser{
+		{TsId: "10", Nickname: "Alice", Clid: "5", Cid: "1"},
+		{TsId: "13", Nickname: "Dave", Clid: "6", Cid: "1"},
+	})
+
+	sync_sessions(presence, last_seen, &NotificationsContext{teamspeak: teamspeak, repository: repository})
+
+	sessions, err := repository.GetAllSessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 4 {
+		t.Fatalf("got %d sessions, want 4: %+v", len(sessions), sessions)
+	}
+	for _, session := range sessions {
+		switch session.TsId {
+		case "10":
+			// Alice never left, her session goes on.
+			if session.Id != "a" || !session.Open {
+				t.Errorf("session of Alice was split or closed: %+v", session)
+			}
+		case "11":
+			if session.Open || !session.End.Equal(last_seen) {
+				t.Errorf("session of Bob ended at %v, want %v", session.End, last_seen)
+			}
+		case "12":
+			// Started after the last sign of life, nothing is counted.
+			if session.Open || !session.End.Equal(session.Start) {
+				t.Errorf("session of Carol ended at %v, want %v", session.End, session.Start)
+			}
+		case "13":
+			if !session.Open || session.Start.Before(now) || session.Channel != "Default Channel" {
+				t.Errorf("unexpected session of Dave %+v", session)
+			}
+		}
+	}
+}
+
+func TestFindUsersByName(t *testing.T) {
+	users := []TeamspeakUser{{TsId: "10", Nickname: "Anna"}, {TsId: "11", Nickname: "Annabelle"}, {TsId: "12", Nickname: "Joanna"}}
+	if matches := find_users_by_name("ANNA", users); len(matches) != 1 || matches[0].TsId != "10" {
+		t.Fatalf("exact match: got %+v", matches)
+	}
+	if matches := find_users_by_name("nna", users); len(matches) != 3 {
+		t.Fatalf("partial match: got %+v", matches)
+	}
+	if matches := find_users_by_name("bob", users); len(matches) != 0 {
+		t.Fatalf("no match: got %+v", matches)
+	}
+}
+
+func TestEndSessionRecordsPlaytime(t *testing.T) {
+	repository := NewMemoryStore()
+	start := time.Date(2024, 1, 15, 23, 30, 0, 0, time.UTC)
+	repository.StartSession(Session{Id: "a", TsId: "10", Nickname: "Alice", Start: start})
+
+	end_session("10", start.Add(time.Hour), "", repository)
+
+	playtime, err := repository.GetPlaytime("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The session is split at midnight.
+	if len(playtime) != 2 || playtime[0].Seconds != 1800 || playtime[1].Seconds != 1800 ||
+		playtime[0].Hours["23"] != 1800 || playtime[1].Hours["0"] != 1800 {
+		t.Fatalf("unexpected playtime %+v", playtime)
+	}
+}
+
+func TestLastActivity(t *testing.T) {
+	repository := NewMemoryStore()
+	now := time.Now().Truncate(time.Second)
+	if last := last_activity(repository); !last.IsZero() {
+		t.Fatalf("got %v without open sessions", last)
+	}
+	repository.StartSession(Session{Id: "a", TsId: "10", Start: now.Add(-2 * time.Hour)})
+	if last := last_activity(repository); !last.IsZero() {
+		t.Fatalf("got %v without samples", last)
+	}
+	for _, ago := range []time.Duration{3 * time.Hour, 90 * time.Minute, time.Hour} {
+		repository.AddActivitySample(ActivitySample{Time: now.Add(-ago), Count: 1})
+	}
+	if last := last_activity(repository); !last.Equal(now.Add(-time.Hour)) {
+		t.Fatalf("got %v, want %v", last, now.Add(-time.Hour))
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const stats_day_format = "2006-01-02"
+const stats_leaderboard_size = 10
+
+// playtime_counters splits the part of session before end into one counter
+// per UTC day, with the seconds attributed to their UTC hours.
+func playtime_counters(session Session, end time.Time) []PlaytimeCounter {
+	var counters []PlaytimeCounter
+	index := make(map[string]int)
+	for current := session.Start.UTC(); current.Before(end); {
+		next := current.Truncate(time.Hour).Add(time.Hour)
+		if next.After(end) {
+			next = end
+		}
+		day := current.Format(stats_day_format)
+		i, ok := index[day]
+		if !ok {
+			i = len(counters)
+			index[day] = i
+			counters = append(counters, PlaytimeCounter{
+				Id:       playtime_counter_id(session.TsId, day),
+				TsId:     session.TsId,
+				Nickname: session.Nickname,
+				Day:      day,
+				Hours:    make(map[string]int64),
+			})
+		}
+		seconds := int64(next.Sub(current).Seconds())
+		counters[i].Seconds += seconds
+		counters[i].Hours[strconv.Itoa(current.Hour())] += seconds
+		current = next
+	}
+	return counters
+}
+
+// record_playtime adds the online time of a finished session to the counters.
+func record_playtime(session Session, end time.Time, repository Store) {
+	for _, counter := range playtime_counters(session, end) {
+		if err := repository.AddPlaytime(counter); err != nil {
+			log.Println("Error adding playtime:", err)
+		}
+	}
+}
+
+// record_peak stores the current number of users online if it is a new peak
+// for the day.
+func record_peak(presence *Presence, repository Store) {
+	now := time.Now()
+	peak := DailyPeak{Day: now.UTC().Format(stats_day_format), Peak: presence.Count(), Time: now}
+	if err := repository.RecordPeak(peak); err != nil {
+		log.Println("Error recording peak:", err)
+	}
+}
+
+type UserPlaytime struct {
+	TsId     string
+	Nickname string
+	Seconds  int64
+}
+
+type PlaytimeStats struct {
+	Counters []PlaytimeCounter
+	// Leaderboard is ordered by online time, longest first.
+	Leaderboard []UserPlaytime
+	Peak        DailyPeak
+}
+
+// stats_since returns the first day included in period, or an empty string
+// for all time.
+func stats_since(period string, now time.Time) (string, error) {
+	switch period {
+	case "week":
+		return now.UTC().AddDate(0, 0, -6).Format(stats_day_format), nil
+	case "month":
+		return now.UTC().AddDate(0, 0, -29).Format(stats_day_format), nil
+	case "all":
+		return "", nil
+	}
+	return "", fmt.Errorf("unknown period %q", period)
+}
+
+// collect_stats aggregates the stored counters from since_day on. Sessions
+// that are still open are counted up to now.
+func collect_stats(since_day string, repository Store) (PlaytimeStats, error) {
+	var stats PlaytimeStats
+	counters, err := repository.GetPlaytime(since_day)
+	if err != nil {
+		return stats, err
+	}
+	open, err := repository.GetOpenSessions()
+	if err != nil {
+		return stats, err
+	}
+	now := time.Now()
+	for _, session := range open {
+		for _, counter := range playtime_counters(session, now) {
+			if counter.Day >= since_day {
+				counters = append(counters, counter)
+			}
+		}
+	}
+	stats.Counters = merge_counters(counters)
+
+	totals := make(map[string]*UserPlaytime)
+	for _, counter := range stats.Counters {
+		total, ok := totals[counter.TsId]
+		if !ok {
+			total = &UserPlaytime{TsId: counter.TsId}
+			totals[counter.TsId] = total
+		}
+		total.Nickname = counter.Nickname
+		total.Seconds += counter.Seconds
+	}
+	for _, total := range totals {
+		stats.Leaderboard = append(stats.Leaderboard, *total)
+	}
+	sort.Slice(stats.Leaderboard, func(i, j int) bool {
+		return stats.Leaderboard[i].Seconds > stats.Leaderboard[j].Seconds
+	})
+
+	peaks, err := repository.GetPeaks(since_day)
+	if err != nil {
+		return stats, err
+	}
+	for _, peak := range peaks {
+		if peak.Peak > stats.Peak.Peak {
+			stats.Peak = peak
+		}
+	}
+	return stats, nil
+}
+
+// merge_counters sums up the counters of the same user and day.
+func merge_counters(counters []PlaytimeCounter) []PlaytimeCounter {
+	var merged []PlaytimeCounter
+	index := make(map[string]int)
+	for _, counter := range counters {
+		i, ok := index[counter.Id]
+		if !ok {
+			index[counter.Id] = len(merged)
+			counter.Hours = copy_hours(counter.Hours)
+			merged = append(merged, counter)
+			continue
+		}
+		merged[i].Seconds += counter.Seconds
+		for hour, seconds := range counter.Hours {
+			merged[i].Hours[hour] += seconds
+		}
+	}
+	return merged
+}
+
+func copy_hours(hours map[string]int64) map[string]int64 {
+	result := make(map[string]int64, len(hours))
+	for hour, seconds := range hours {
+		result[hour] = seconds
+	}
+	return result
+}
+
+// busiest_hours returns the count hours with the most online time, as the
+// time of day in location they start at. Each UTC hour is converted on its
+// own day, so half-hour offsets and DST changes within the period are
+// accounted for.
+func busiest_hours(stats PlaytimeStats, location *time.Location, count int) []string {
+	totals := make(map[string]int64)
+	for _, counter := range stats.Counters {
+		day, err := time.Parse(stats_day_format, counter.Day)
+		if err != nil {
+			continue
+		}
+		for hour, seconds := range counter.Hours {
+			h, err := strconv.Atoi(hour)
+			if err != nil || h < 0 || h >= 24 || seconds <= 0 {
+				continue
+			}
+			totals[day.Add(time.Duration(h)*time.Hour).In(location).Format("15:04")] += seconds
+		}
+	}
+	hours := make([]string, 0, len(totals))
+	for hour := range totals {
+		hours = append(hours, hour)
+	}
+	sort.Slice(hours, func(i, j int) bool {
+		if totals[hours[i]] != totals[hours[j]] {
+			return totals[hours[i]] > totals[hours[j]]
+		}
+		return hours[i] < hours[j]
+	})
+	if len(hours) > count {
+		hours = hours[:count]
+	}
+	return hours
+}
+
+func format_stats(stats PlaytimeStats, title string, location *time.Location) string {
+	if len(stats.Leaderboard) == 0 {
+		return "No activity recorded " + title
+	}
+	text := fmt.Sprintf("Statistics %s:\n\nLeaderboard:\n", title)
+	for i, user := range stats.Leaderboard {
+		if i == stats_leaderboard_size {
+			break
+		}
+		text += fmt.Sprintf("%d. %s - %s\n", i+1, user.Nickname, format_duration(time.Duration(user.Seconds)*time.Second))
+	}
+	if stats.Peak.Peak > 0 {
+		text += fmt.Sprintf("\nPeak: %d users online on %s\n", stats.Peak.Peak, stats.Peak.Time.In(location).Format("2006-01-02 15:04"))
+	}
+	if hours := busiest_hours(stats, location, 3); len(hours) > 0 {
+		text += "Busiest hours: " + strings.Join(hours, ", ") + "\n"
+	}
+	return text
+}
+
+// stats_csv exports one row per user and UTC day.
+func stats_csv(stats PlaytimeStats) ([]byte, error) {
+	counters := append([]PlaytimeCounter(nil), stats.Counters...)
+	sort.Slice(counters, func(i, j int) bool {
+		if counters[i].Day != counters[j].Day {
+			return counters[i].Day < counters[j].Day
+		}
+		return counters[i].TsId < counters[j].TsId
+	})
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+	writer.Write([]string{"day", "ts_id", "nickname", "seconds"})
+	for _, counter := range counters {
+		writer.Write([]string{counter.Day, counter.TsId, counter.Nickname, strconv.FormatInt(counter.Seconds, 10)})
+	}
+	writer.Flush()
+	return buffer.Bytes(), writer.Error()
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBusiestHours(t *testing.T) {
+	stats := PlaytimeStats{Counters: []PlaytimeCounter{
+		// Europe/Berlin switches to summer time on 2024-03-31.
+		{TsId: "10", Day: "2024-03-30", Hours: map[string]int64{"20": 1800, "21": 600}},
+		{TsId: "10", Day: "2024-04-01", Hours: map[string]int64{"20": 3000, "3": 100}},
+		{TsId: "11", Day: "2024-04-01", Hours: map[string]int64{"20": 600, "x": 9999, "4": 0}},
+	}}
+	tests := []struct {
+		zone string
+		want []string
+	}{
+		{"UTC", []string{"20:00", "21:00", "03:00"}},
+		{"Asia/Kolkata", []string{"01:30", "02:30", "08:30"}},
+		{"Europe/Berlin", []string{"22:00", "21:00", "05:00"}},
+	}
+	for _, test := range tests {
+		location, err := time.LoadLocation(test.zone)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := busiest_hours(stats, location, 3); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.zone, got, test.want)
+		}
+	}
+}
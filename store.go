@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ErrNotFound is returned by a Store when a requested entry does not exist.
+var ErrNotFound = errors.New("not found")
+
+// ErrInviteUnusable is returned by UseInvite for an invite that expired or
+// has no uses left.
+var ErrInviteUnusable = errors.New("invite expired or used up")
+
+// ErrAccessRequestPending is returned by AddAccessRequest if the user
+// already has a pending request.
+var ErrAccessRequestPending = errors.New("access request pending")
+
+// Store is the persistence layer used by the bot. Every command and the
+// notifications loop go through it, so the backend can be swapped without
+// touching the callers.
+type Store interface {
+	AddWhiteListEntry(telegram_id int64, alias string) error
+	RemoveWhiteListEntry(telegram_id int64) error
+	IsOnWhitelist(telegram_id int64) (bool, error)
+	GetWhiteList() ([]WhiteListEntry, error)
+
+	AddSubscriber(subscriber_telegram_id int64, subscribed_teamspeak_id string, subscribed_teamspeak_name string) error
+	RemoveSubscriber(subscriber_telegram_id int64, subscribed_teamspeak_id string) error
+	GetSubscribers(subscribed_teamspeak_id string) (UserSubscribers, error)
+	GetSubscribedTeamspeaks(subscriber_telegram_id int64) ([]UserSubscribers, error)
+	GetAllSubscribers() ([]UserSubscribers, error)
+
+	AddQuote(quote Quote) error
+	GetAllQuotes() ([]Quote, error)
+	DeleteQuote(uuid string) error
+
+	SetProperty(property Property) error
+	GetProperty(id string, defaultValueFunc func() string) (Property, error)
+	GetAllProperties() ([]Property, error)
+	SetQuotesChannel(value string) error
+	GetQuotesChannel() (string, bool)
+
+	SetChatRelay(relay ChatRelay) error
+	GetChatRelay() (ChatRelay, error)
+	RemoveChatRelay() error
+
+	// GetUserSettings returns the default settings if none were stored yet.
+	GetUserSettings(telegram_id int64) (UserSettings, error)
+	SetUserSettings(settings UserSettings) error
+	GetAllUserSettings() ([]UserSettings, error)
+
+	AddDigestEvent(telegram_id int64, event DigestEvent) error
+	GetPendingDigests() ([]PendingDigest, error)
+	// SetPendingDigest stores a whole digest as is, replacing the pending
+	// digest of the same user.
+	SetPendingDigest(digest PendingDigest) error
+	// TakePendingDigest removes and returns the pending digest of the user.
+	TakePendingDigest(telegram_id int64) (PendingDigest, error)
+
+	SetGroupBinding(binding GroupBinding) error
+	// RemoveGroupBinding returns ErrNotFound if the chat was not bound.
+	RemoveGroupBinding(chat_id int64) error
+	GetGroupBindings() ([]GroupBinding, error)
+
+	StartSession(session Session) error
+	// EndSession closes all open sessions of the Teamspeak user.
+	EndSession(ts_id string, end time.Time, reason string) error
+	GetOpenSessions() ([]Session, error)
+	// GetSessions returns the sessions of the user that were still going on
+	// at since, newest first.
+	GetSessions(ts_id string, since time.Time) ([]Session, error)
+	GetAllSessions() ([]Session, error)
+	// SetSession stores a session as is, replacing one with the same id.
+	SetSession(session Session) error
+	GetLastSession(ts_id string) (Session, error)
+
+	// AddPlaytime adds the seconds of counter to the stored counter of the
+	// same user and day.
+	AddPlaytime(counter PlaytimeCounter) error
+	// GetPlaytime returns the counters of all days from since_day on, which
+	// is formatted as 2006-01-02; an empty string returns all of them.
+	GetPlaytime(since_day string) ([]PlaytimeCounter, error)
+	// RecordPeak stores peak unless the day already has a higher one.
+	RecordPeak(peak DailyPeak) error
+	GetPeaks(since_day string) ([]DailyPeak, error)
+
+	AddActivitySample(sample ActivitySample) error
+	// GetActivitySamples returns the samples taken since the given time,
+	// oldest first.
+	GetActivitySamples(since time.Time) ([]ActivitySample, error)
+
+	// SetLinkedIdentity replaces any previous link of either side.
+	SetLinkedIdentity(identity LinkedIdentity) error
+	GetLinkedIdentity(telegram_id int64) (LinkedIdentity, error)
+	GetLinkedIdentityByTsId(ts_id string) (LinkedIdentity, error)
+	// RemoveLinkedIdentity returns ErrNotFound if the user was not linked.
+	RemoveLinkedIdentity(telegram_id int64) error
+	GetAllLinkedIdentities() ([]LinkedIdentity, error)
+
+	SetUserRole(telegram_id int64, role string) error
+	GetUserRole(telegram_id int64) (UserRole, error)
+	// RemoveUserRole returns ErrNotFound if the user had no role assigned.
+	RemoveUserRole(telegram_id int64) error
+	GetUserRoles() ([]UserRole, error)
+
+	GrantPermission(command string, role string) error
+	// RevokePermission returns ErrNotFound if the role was not granted.
+	RevokePermission(command string, role string) error
+	GetPermission(command string) (CommandPermission, error)
+	GetPermissions() ([]CommandPermission, error)
+
+	// AddAdmin replaces any earlier entry of the same user.
+	AddAdmin(admin AdminEntry) error
+	// RemoveAdmin returns ErrNotFound if the user was not an admin.
+	RemoveAdmin(telegram_id int64) error
+	IsAdmin(telegram_id int64) (bool, error)
+	GetAdmins() ([]AdminEntry, error)
+
+	// AddAccessRequest stores the request, replacing one of the same user
+	// made before since. It returns ErrAccessRequestPending if the user has
+	// a newer one.
+	AddAccessRequest(request AccessRequest, since time.Time) error
+	// RemoveAccessRequest returns ErrNotFound if the user has no request.
+	RemoveAccessRequest(telegram_id int64) error
+	GetAccessRequests() ([]AccessRequest, error)
+
+	AddInvite(invite Invite) error
+	// UseInvite counts one use of the invite and returns it, ErrNotFound if
+	// it does not exist or ErrInviteUnusable if it can no longer be used.
+	UseInvite(token string, now time.Time) (Invite, error)
+	// RemoveInvite returns ErrNotFound if the invite does not exist.
+	RemoveInvite(token string) error
+	// GetInvites returns all invites, oldest first.
+	GetInvites() ([]Invite, error)
+}
+
+const (
+	storage_driver_mongodb = "mongodb"
+	storage_driver_bolt    = "bolt"
+	storage_driver_memory  = "memory"
+)
+
+var _ Store = (*Repository)(nil)
+var _ Store = (*KeyValueStore)(nil)
+
+// CreateStore opens the storage backend selected by storage.driver in the
+// config.
+func CreateStore(config *Config) (Store, error) {
+	switch config.Storage.Driver {
+	case storage_driver_mongodb:
+		return CreateRepository(config)
+	case storage_driver_bolt:
+		return NewBoltStore(config.Storage.Path)
+	case storage_driver_memory:
+		log.Println("Using in-memory storage, data will be lost on restart")
+		return NewMemoryStore(), nil
+	}
+	return nil, fmt.Errorf("unknown storage driver %q", config.Storage.Driver)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// test_stores returns one store of every embedded backend.
+func test_stores(t *testing.T) map[string]*KeyValueStore {
+	t.Helper()
+	bolt, err := NewBoltStore(filepath.Join(t.TempDir(), "bridge.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bolt.Close() })
+	return map[string]*KeyValueStore{"memory": NewMemoryStore(), "bolt": bolt}
+}
+
+func TestStoreWhitelist(t *testing.T) {
+	for name, store := range test_stores(t) {
+		if err := store.AddWhiteListEntry(5, "bob"); err != nil {
+			t.Fatal(name, err)
+		}
+		if err := store.AddWhiteListEntry(5, "bob"); err == nil {
+			t.Error(name, "added the same entry twice")
+		}
+		store.AddWhiteListEntry(12, "carol")
+		entries, err := store.GetWhiteList()
+		want := []WhiteListEntry{{Id: "12", Alias: "carol"}, {Id: "5", Alias: "bob"}}
+		if err != nil || !reflect.DeepEqual(entries, want) {
+			t.Errorf("%s: got %+v, %v", name, entries, err)
+		}
+		store.RemoveWhiteListEntry(5)
+		if ok, err := store.IsOnWhitelist(5); ok || err != nil {
+			t.Errorf("%s: 5 still whitelisted: %v", name, err)
+		}
+	}
+}
+
+func TestStoreSubscribers(t *testing.T) {
+	for name, store := range test_stores(t) {
+		store.AddSubscriber(1, "10", "Alice")
+		store.AddSubscriber(1, "10", "Alice")
+		store.AddSubscriber(2, "10", "Alice")
+		store.AddSubscriber(1, "11", "Bob")
+		subscribers, err := store.GetSubscribers("10")
+		if err != nil || !reflect.DeepEqual(subscribers.TelegramSubscribers, []int64{1, 2}) {
+			t.Errorf("%s: got %+v, %v", name, subscribers, err)
+		}
+		store.RemoveSubscriber(1, "10")
+		entries, err := store.GetSubscribedTeamspeaks(1)
+		if err != nil || len(entries) != 1 || entries[0].Id != "11" {
+			t.Errorf("%s: got %+v, %v", name, entries, err)
+		}
+		if _, err := store.GetSubscribers("12"); err != ErrNotFound {
+			t.Errorf("%s: got %v, want ErrNotFound", name, err)
+		}
+	}
+}
+
+func TestStoreQuotesAndProperties(t *testing.T) {
+	for name, store := range test_stores(t) {
+		store.AddQuote(Quote{UUID: "a", Author: "Alice", Content: "to be or not"})
+		if err := store.AddQuote(Quote{UUID: "a"}); err == nil {
+			t.Error(name, "added the same quote twice")
+		}
+		store.DeleteQuote("a")
+		if quotes, err := store.GetAllQuotes(); err != nil || len(quotes) != 0 {
+			t.Errorf("%s: got %+v, %v", name, quotes, err)
+		}
+
+		if _, ok := store.GetQuotesChannel(); ok {
+			t.Error(name, "quotes channel set by default")
+		}
+		store.SetQuotesChannel("Lobby")
+		if channel, ok := store.GetQuotesChannel(); !ok || channel != "Lobby" {
+			t.Errorf("%s: got %q", name, channel)
+		}
+		property, err := store.GetProperty("missing", func() string { return "default" })
+		if err != nil || property.Value != "default" {
+			t.Errorf("%s: got %+v, %v", name, property, err)
+		}
+	}
+}
+
+func TestStorePendingDigests(t *testing.T) {
+	since := time.Date(2024, 1, 15, 10, 5, 0, 0, time.UTC)
+	for name, store := range test_stores(t) {
+		store.AddDigestEvent(42, DigestEvent{Kind: template_connect, TsId: "10", Name: "Alice", Time: since})
+		store.AddDigestEvent(42, DigestEvent{Kind: template_disconnect, TsId: "10", Name: "Alice", Time: since.Add(time.Hour)})
+		digests, err := store.GetPendingDigests()
+		if err != nil || len(digests) != 1 || len(digests[0].Events) != 2 || !digests[0].Since.Equal(since) {
+			t.Errorf("%s: got %+v, %v", name, digests, err)
+		}
+
+		// A stored digest keeps its own start.
+		earlier := since.Add(-time.Hour)
+		store.SetPendingDigest(PendingDigest{Id: "43", Since: earlier, Events: digests[0].Events[:1]})
+		digest, err := store.TakePendingDigest(43)
+		if err != nil || !digest.Since.Equal(earlier) || len(digest.Events) != 1 {
+			t.Errorf("%s: got %+v, %v", name, digest, err)
+		}
+		if _, err := store.TakePendingDigest(43); err != ErrNotFound {
+			t.Errorf("%s: digest was not removed: %v", name, err)
+		}
+	}
+}
+
+func TestStoreSessions(t *testing.T) {
+	start := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	for name, store := range test_stores(t) {
+		store.StartSession(Session{Id: "a", TsId: "10", Nickname: "Alice", Start: start})
+		store.StartSession(Session{Id: "b", TsId: "11", Nickname: "Bob", Start: start.Add(time.Hour)})
+		store.EndSession("10", start.Add(30*time.Minute), "connection lost")
+		open, err := store.GetOpenSessions()
+		if err != nil || len(open) != 1 || open[0].Id != "b" {
+			t.Errorf("%s: got open %+v, %v", name, open, err)
+		}
+		sessions, err := store.GetSessions("10", start)
+		if err != nil || len(sessions) != 1 || sessions[0].Reason != "connection lost" {
+			t.Errorf("%s: got %+v, %v", name, sessions, err)
+		}
+		if sessions, _ := store.GetSessions("10", start.Add(time.Hour)); len(sessions) != 0 {
+			t.Errorf("%s: got sessions that ended before since: %+v", name, sessions)
+		}
+		store.StartSession(Session{Id: "c", TsId: "10", Nickname: "Alicia", Start: start.Add(2 * time.Hour)})
+		if last, err := store.GetLastSession("10"); err != nil || last.Id != "c" {
+			t.Errorf("%s: got last %+v, %v", name, last, err)
+		}
+		if _, err := store.GetLastSession("12"); err != ErrNotFound {
+			t.Errorf("%s: got %v for an unknown user", name, err)
+		}
+	}
+}
+
+func TestStoreActivitySamples(t *testing.T) {
+	now := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	for name, store := range test_stores(t) {
+		for _, ago := range []time.Duration{time.Hour, 3 * time.Hour, 2 * time.Hour} {
+			if err := store.AddActivitySample(ActivitySample{Time: now.Add(-ago), Count: int(ago / time.Hour)}); err != nil {
+				t.Fatal(name, err)
+			}
+		}
+		samples, err := store.GetActivitySamples(now.Add(-150 * time.Minute))
+		if err != nil || len(samples) != 2 || samples[0].Count != 2 || samples[1].Count != 1 {
+			t.Errorf("%s: got %+v, %v", name, samples, err)
+		}
+	}
+}
+
+func TestStoreAccessRequests(t *testing.T) {
+	now := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	for name, store := range test_stores(t) {
+		request := AccessRequest{TelegramId: 3, RequestedAt: now}
+		if err := store.AddAccessRequest(request, now.Add(-time.Hour)); err != nil {
+			t.Fatal(name, err)
+		}
+		if err := store.AddAccessRequest(request, now.Add(-time.Hour)); err != ErrAccessRequestPending {
+			t.Errorf("%s: second request got %v", name, err)
+		}
+		// An old request no longer blocks a new one.
+		later := AccessRequest{TelegramId: 3, RequestedAt: now.Add(2 * time.Hour)}
+		if err := store.AddAccessRequest(later, now.Add(time.Hour)); err != nil {
+			t.Errorf("%s: request after the old one expired got %v", name, err)
+		}
+		requests, err := store.GetAccessRequests()
+		if err != nil || len(requests) != 1 || !requests[0].RequestedAt.Equal(later.RequestedAt) {
+			t.Errorf("%s: got %+v, %v", name, requests, err)
+		}
+		if err := store.RemoveAccessRequest(3); err != nil {
+			t.Error(name, err)
+		}
+		if err := store.RemoveAccessRequest(3); err != ErrNotFound {
+			t.Errorf("%s: removing twice got %v", name, err)
+		}
+	}
+}
+
+func TestStoreInvites(t *testing.T) {
+	now := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	for name, store := range test_stores(t) {
+		store.AddInvite(Invite{Token: "b", CreatedAt: now, ExpiresAt: now.Add(time.Hour), MaxUses: 2})
+		store.AddInvite(Invite{Token: "a", CreatedAt: now.Add(-time.Hour), ExpiresAt: now.Add(-time.Minute), MaxUses: 1})
+		if err := store.AddInvite(Invite{Token: "a"}); err == nil {
+			t.Error(name, "added the same invite twice")
+		}
+		for i := 1; i <= 2; i++ {
+			if invite, err := store.UseInvite("b", now); err != nil || invite.Uses != i {
+				t.Errorf("%s: use %d got %+v, %v", name, i, invite, err)
+			}
+		}
+		if _, err := store.UseInvite("b", now); err != ErrInviteUnusable {
+			t.Errorf("%s: used up invite got %v", name, err)
+		}
+		if _, err := store.UseInvite("a", now); err != ErrInviteUnusable {
+			t.Errorf("%s: expired invite got %v", name, err)
+		}
+		if _, err := store.UseInvite("c", now); err != ErrNotFound {
+			t.Errorf("%s: unknown invite got %v", name, err)
+		}
+		invites, err := store.GetInvites()
+		if err != nil || len(invites) != 2 || invites[0].Token != "a" || invites[1].Uses != 2 {
+			t.Errorf("%s: got %+v, %v", name, invites, err)
+		}
+		if err := store.RemoveInvite("a"); err != nil {
+			t.Error(name, err)
+		}
+		if err := store.RemoveInvite("a"); err != ErrNotFound {
+			t.Errorf("%s: removing twice got %v", name, err)
+		}
+	}
+}
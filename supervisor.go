@@ -0,0 +1,380 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/multiplay/go-ts3"
+)
+
+// reconnected_notification is injected into the notifications channel after
+// the supervisor re-established the connection, so that the notifications
+// loop can re-snapshot the server state before handling newer events. Its
+// last_alive field holds the time of the last successful command before the
+// connection dropped. disconnected_notification is injected when it drops.
+const (
+	reconnected_notification  = "bridge_reconnected"
+	disconnected_notification = "bridge_disconnected"
+)
+
+const (
+	// notification_buffer_size is the buffer of each ts3.Client. The client
+	// drops notifications once it is full, so it only has to cover the time
+	// until the forwarder picks them up.
+	notification_buffer_size   = 1024
+	default_keepalive_interval = 60 * time.Second
+	min_reconnect_backoff      = time.Second
+	max_reconnect_backoff      = 5 * time.Minute
+)
+
+var (
+	ErrTeamspeakDisconnected = errors.New("not connected to Teamspeak")
+	ErrTeamspeakDropped      = errors.New("connection dropped")
+)
+
+// TeamspeakSupervisor owns the ServerQuery connection. It implements
+// TeamspeakClient by delegating to the current connection, sends periodic
+// keepalives and transparently reconnects with exponential backoff when the
+// connection drops. Notifications of every connection are forwarded into a
+// single channel that stays open across reconnects.
+type TeamspeakSupervisor struct {
+	config        *Config
+	keepalive     time.Duration
+	notifications chan ts3.Notification
+
+	// Forwarded notifications are queued without a limit, so a slow
+	// consumer never makes the forwarder block and go-ts3 drop events.
+	queue_mutex sync.Mutex
+	queue       []ts3.Notification
+	queued      chan struct{}
+
+	// OnDisconnect and OnReconnect are called from the supervisor goroutine
+	// when the link goes down and when it is back up again.
+	OnDisconnect func(err error)
+	OnReconnect  func(downtime time.Duration)
+
+	// dropped receives a connection as soon as it is known to be gone, so
+	// Run can reconnect without waiting for the next keepalive.
+	dropped chan *ts3.Client
+
+	exec_mutex sync.Mutex
+	mutex      sync.Mutex
+	client     *ts3.Client
+	done       chan struct{}
+	registered []ts3.NotifyCategory
+	// alive is when the server last answered a command.
+	alive time.Time
+}
+
+func NewTeamspeakSupervisor(config *Config) *TeamspeakSupervisor {
+	keepalive := time.Duration(config.Bot.TeamspeakKeepalive) * time.Second
+	if keepalive <= 0 {
+		keepalive = default_keepalive_interval
+	}
+	supervisor := &TeamspeakSupervisor{
+		config:        config,
+		keepalive:     keepalive,
+		notifications: make(chan ts3.Notification),
+		queued:        make(chan struct{}, 1),
+		dropped:       make(chan *ts3.Client, 1),
+	}
+	go supervisor.deliver()
+	return supervisor
+}
+
+// enqueue adds a notification to the queue without blocking.
+func (supervisor *TeamspeakSupervisor) enqueue(notification ts3.Notification) {
+	supervisor.queue_mutex.Lock()
+	supervisor.queue = append(supervisor.queue, notification)
+	supervisor.queue_mutex.Unlock()
+	select {
+	case supervisor.queued <- struct{}{}:
+	default:
+	}
+}
+
+// deliver passes queued notifications on to the notifications channel in
+// order. It never returns.
+func (supervisor *TeamspeakSupervisor) deliver() {
+	for range supervisor.queued {
+		for {
+			supervisor.queue_mutex.Lock()
+			if len(supervisor.queue) == 0 {
+				supervisor.queue_mutex.Unlock()
+				break
+			}
+			notification := supervisor.queue[0]
+			supervisor.queue[0] = ts3.Notification{}
+			supervisor.queue = supervisor.queue[1:]
+			supervisor.queue_mutex.Unlock()
+			supervisor.notifications <- notification
+		}
+	}
+}
+
+// Connect opens the initial connection.
+func (supervisor *TeamspeakSupervisor) Connect() error {
+	client, err := supervisor.dial()
+	if err != nil {
+		return err
+	}
+	supervisor.attach(client)
+	return nil
+}
+
+// Run sends keepalives and reconnects whenever one fails or the connection
+// is found to be dropped in between. It never returns.
+func (supervisor *TeamspeakSupervisor) Run() {
+	keepalive := time.NewTicker(supervisor.keepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-keepalive.C:
+			if _, err := supervisor.Exec("version"); err != nil {
+				log.Println("Teamspeak keepalive failed:", err)
+				supervisor.reconnect(err)
+			}
+		case client := <-supervisor.dropped:
+			// Ignore connections that were already replaced or closed.
+			if current, err := supervisor.current(); err == nil && current == client {
+				log.Println("Teamspeak connection dropped")
+				supervisor.reconnect(ErrTeamspeakDropped)
+			}
+		}
+	}
+}
+
+func (supervisor *TeamspeakSupervisor) reconnect(cause error) {
+	down_since := time.Now()
+	supervisor.mutex.Lock()
+	last_alive := supervisor.alive
+	supervisor.mutex.Unlock()
+	supervisor.detach()
+	supervisor.enqueue(ts3.Notification{Type: disconnected_notification})
+	if supervisor.OnDisconnect != nil {
+		supervisor.OnDisconnect(cause)
+	}
+	backoff := min_reconnect_backoff
+	for {
+		log.Println("Reconnecting to Teamspeak in", backoff)
+		time.Sleep(backoff)
+		client, err := supervisor.dial()
+		if err == nil {
+			if err = supervisor.restore(client); err == nil {
+				// Make the new connection current before the marker is
+				// seen, so the re-snapshot runs against it, and only then
+				// forward its notifications, so they come after the marker.
+				done := supervisor.use(client)
+				supervisor.enqueue(ts3.Notification{
+					Type: reconnected_notification,
+					Data: map[string]string{"last_alive": last_alive.Format(time.RFC3339Nano)},
+				})
+				supervisor.forward(client, done)
+				break
+			}
+			client.Close()
+		}
+		log.Println("Teamspeak reconnect failed:", err)
+		backoff *= 2
+		if backoff > max_reconnect_backoff {
+			backoff = max_reconnect_backoff
+		}
+	}
+	downtime := time.Since(down_since)
+	log.Println("Reconnected to Teamspeak after", downtime)
+	if supervisor.OnReconnect != nil {
+		supervisor.OnReconnect(downtime)
+	}
+}
+
+// dial connects, logs in and selects the virtual server.
+func (supervisor *TeamspeakSupervisor) dial() (*ts3.Client, error) {
+	bot := supervisor.config.Bot
+	host := fmt.Sprintf("%s:%d", bot.TeamspeakHost, bot.TeamspeakQueryPort)
+	client, err := ts3.NewClient(host, ts3.NotificationBuffer(notification_buffer_size))
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Login(bot.TeamspeakUser, bot.TeamspeakPassword); err != nil {
+		client.Close()
+		return nil, err
+	}
+	if err := client.UsePort(bot.TeamspeakPort); err != nil {
+		client.Close()
+		return nil, err
+	}
+	log.Println("Connected to Teamspeak server on port", bot.TeamspeakPort)
+	if v, err := client.Version(); err != nil {
+		client.Close()
+		return nil, err
+	} else {
+		log.Println("Connected to Teamspeak server version", v.Version)
+	}
+	if info, err := getTeamspeakServerInfo(client); err == nil {
+		log.Printf("Virtual server %q has %d/%d clients online", info.Name, info.ClientsOnline-info.QueryClientsOnline, info.MaxClients)
+	}
+	return client, nil
+}
+
+// restore re-registers for the notifications registered on the previous
+// connection.
+func (supervisor *TeamspeakSupervisor) restore(client *ts3.Client) error {
+	supervisor.mutex.Lock()
+	registered := append([]ts3.NotifyCategory{}, supervisor.registered...)
+	supervisor.mutex.Unlock()
+	for _, event := range registered {
+		if err := client.Register(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// attach makes client the current connection and starts forwarding its
+// notifications.
+func (supervisor *TeamspeakSupervisor) attach(client *ts3.Client) {
+	supervisor.forward(client, supervisor.use(client))
+}
+
+// use makes client the current connection. The returned channel is closed
+// when it is detached again.
+func (supervisor *TeamspeakSupervisor) use(client *ts3.Client) chan struct{} {
+	done := make(chan struct{})
+	supervisor.mutex.Lock()
+	supervisor.client = client
+	supervisor.done = done
+	supervisor.alive = time.Now()
+	supervisor.mutex.Unlock()
+	return done
+}
+
+// forward copies the notifications of client into the supervisor's channel
+// until done is closed. If the client's channel closes before that, the
+// connection is gone and Run is told to reconnect.
+func (supervisor *TeamspeakSupervisor) forward(client *ts3.Client, done chan struct{}) {
+	go func() {
+		source := client.Notifications()
+		for {
+			select {
+			case notification, ok := <-source:
+				if !ok {
+					select {
+					case <-done:
+					default:
+						supervisor.drop(client)
+					}
+					return
+				}
+				supervisor.enqueue(notification)
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// connection_lost reports whether err means the socket itself is gone, as
+// opposed to the server rejecting a command.
+func connection_lost(err error) bool {
+	var net_error net.Error
+	return errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, ts3.ErrNotConnected) ||
+		errors.As(err, &net_error)
+}
+
+// drop tells Run that client lost its connection.
+func (supervisor *TeamspeakSupervisor) drop(client *ts3.Client) {
+	select {
+	case supervisor.dropped <- client:
+	default:
+	}
+}
+
+// detach stops forwarding notifications of the current connection and
+// closes it.
+func (supervisor *TeamspeakSupervisor) detach() {
+	supervisor.mutex.Lock()
+	client := supervisor.client
+	if supervisor.done != nil {
+		close(supervisor.done)
+	}
+	supervisor.client = nil
+	supervisor.done = nil
+	supervisor.mutex.Unlock()
+	if client != nil {
+		// Closing a half-dead ts3.Client can block until its own timeout,
+		// don't hold up reconnecting for it.
+		go client.Close()
+	}
+}
+
+func (supervisor *TeamspeakSupervisor) current() (*ts3.Client, error) {
+	supervisor.mutex.Lock()
+	defer supervisor.mutex.Unlock()
+	if supervisor.client == nil {
+		return nil, ErrTeamspeakDisconnected
+	}
+	return supervisor.client, nil
+}
+
+func (supervisor *TeamspeakSupervisor) Exec(cmd string) ([]string, error) {
+	return supervisor.ExecCmd(ts3.NewCmd(cmd))
+}
+
+// ExecCmd runs cmd on the current connection. Commands are serialized since
+// ts3.Client does not support concurrent use.
+func (supervisor *TeamspeakSupervisor) ExecCmd(cmd *ts3.Cmd) ([]string, error) {
+	client, err := supervisor.current()
+	if err != nil {
+		return nil, err
+	}
+	supervisor.exec_mutex.Lock()
+	defer supervisor.exec_mutex.Unlock()
+	response, err := client.ExecCmd(cmd)
+	if err == nil {
+		supervisor.mutex.Lock()
+		supervisor.alive = time.Now()
+		supervisor.mutex.Unlock()
+	}
+	// ts3.Client only notices a dropped socket when a command reads the
+	// error, so whichever command does so hands it over to Run.
+	if connection_lost(err) {
+		supervisor.drop(client)
+	}
+	return response, err
+}
+
+// Register registers for event on the current connection and remembers it,
+// so it is registered again after a reconnect.
+func (supervisor *TeamspeakSupervisor) Register(event ts3.NotifyCategory) error {
+	supervisor.mutex.Lock()
+	known := false
+	for _, registered := range supervisor.registered {
+		known = known || registered == event
+	}
+	if !known {
+		supervisor.registered = append(supervisor.registered, event)
+	}
+	supervisor.mutex.Unlock()
+	client, err := supervisor.current()
+	if err != nil {
+		return err
+	}
+	supervisor.exec_mutex.Lock()
+	defer supervisor.exec_mutex.Unlock()
+	return client.Register(event)
+}
+
+func (supervisor *TeamspeakSupervisor) Notifications() <-chan ts3.Notification {
+	return supervisor.notifications
+}
+
+func (supervisor *TeamspeakSupervisor) Close() error {
+	supervisor.detach()
+	return nil
+}
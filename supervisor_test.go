@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"bridge/ts3fake"
+
+	"github.com/multiplay/go-ts3"
+)
+
+// start_supervisor runs a supervisor against a fake server and returns a
+// channel receiving the downtime of every reconnect.
+func start_supervisor(t *testing.T) (*ts3fake.Server, *TeamspeakSupervisor, chan time.Duration) {
+	t.Helper()
+	server, supervisor := newFakeTeamspeak(t)
+	reconnected := make(chan time.Duration, 10)
+	supervisor.OnReconnect = func(downtime time.Duration) { reconnected <- downtime }
+	if err := supervisor.Register(ts3.ServerEvents); err != nil {
+		t.Fatal(err)
+	}
+	go supervisor.Run()
+	return server, supervisor, reconnected
+}
+
+// expect_reconnect waits for a reconnect well before the next keepalive and
+// checks that notifications are forwarded again, after the markers.
+func expect_reconnect(t *testing.T, server *ts3fake.Server, supervisor *TeamspeakSupervisor, reconnected chan time.Duration) {
+	t.Helper()
+	select {
+	case <-reconnected:
+	case <-time.After(test_timeout):
+		t.Fatal("timed out waiting for the reconnect")
+	}
+	server.ClientEnter(ts3fake.Client{DatabaseID: 10, Nickname: "Alice"})
+	for _, want := range []string{disconnected_notification, reconnected_notification, "cliententerview"} {
+		select {
+		case notification := <-supervisor.Notifications():
+			if notification.Type != want {
+				t.Fatalf("got notification %q, want %q", notification.Type, want)
+			}
+			if want == reconnected_notification {
+				last_alive, err := time.Parse(time.RFC3339Nano, notification.Data["last_alive"])
+				if err != nil || time.Since(last_alive) > test_timeout {
+					t.Fatalf("unexpected last_alive %q", notification.Data["last_alive"])
+				}
+			}
+		case <-time.After(test_timeout):
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+}
+
+func TestSupervisorReconnectsOnDrop(t *testing.T) {
+	server, supervisor, reconnected := start_supervisor(t)
+
+	server.DropConnections()
+	if _, err := supervisor.Exec("version"); err == nil {
+		t.Fatal("command on a dropped connection succeeded")
+	}
+	expect_reconnect(t, server, supervisor, reconnected)
+}
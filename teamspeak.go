@@ -4,125 +4,197 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"strings"
 
 	"github.com/multiplay/go-ts3"
 )
 
+// TeamspeakClient is the set of ServerQuery operations the bot uses.
+// *ts3.Client implements it; tests can point a real client at the fake
+// server in the ts3fake package.
+type TeamspeakClient interface {
+	Exec(cmd string) ([]string, error)
+	ExecCmd(cmd *ts3.Cmd) ([]string, error)
+	Register(event ts3.NotifyCategory) error
+	Notifications() <-chan ts3.Notification
+}
+
+var _ TeamspeakClient = (*ts3.Client)(nil)
+
 type TeamspeakUser struct {
 	TsId     string
 	Nickname string
+	// Clid, Cid and IsQuery are only known for online users.
+	Clid string
+	Cid  string
+	// IsQuery is set for ServerQuery connections (client_type=1), such as
+	// the bot itself.
+	IsQuery bool
+}
+
+// queryClient is an entry of the clientlist response.
+type queryClient struct {
+	Clid       string `query:"clid"`
+	Cid        string `query:"cid"`
+	DatabaseId string `query:"client_database_id"`
+	Nickname   string `query:"client_nickname"`
+	Type       int    `query:"client_type"`
+}
+
+// queryDatabaseClient is an entry of the clientdblist response.
+type queryDatabaseClient struct {
+	DatabaseId string `query:"cldbid"`
+	Nickname   string `query:"client_nickname"`
+}
+
+type TeamspeakChannel struct {
+	Cid          string `query:"cid"`
+	ParentCid    string `query:"pid"`
+	Name         string `query:"channel_name"`
+	TotalClients int    `query:"total_clients"`
+}
+
+type TeamspeakServerInfo struct {
+	Name               string `query:"virtualserver_name"`
+	Port               int    `query:"virtualserver_port"`
+	ClientsOnline      int    `query:"virtualserver_clientsonline"`
+	QueryClientsOnline int    `query:"virtualserver_queryclientsonline"`
+	MaxClients         int    `query:"virtualserver_maxclients"`
+	Uptime             int    `query:"virtualserver_uptime"`
+}
+
+// TeamspeakWhoami describes the bot's own query connection.
+type TeamspeakWhoami struct {
+	ClientId  string `query:"client_id"`
+	ChannelId string `query:"client_channel_id"`
+	Nickname  string `query:"client_nickname"`
 }
 
-func getTeamspeakUsers(client *ts3.Client) ([]TeamspeakUser, error) {
+func getTeamspeakUsers(client TeamspeakClient) ([]TeamspeakUser, error) {
 	list, err := client.Exec("clientlist")
 	if err != nil {
 		return nil, err
 	}
-	if len(list) == 0 {
-		return nil, nil
-	}
-	var data string = list[0]
-	var lines []string = strings.Split(data, "|")
-	var users []TeamspeakUser = make([]TeamspeakUser, len(lines))
-	for i, line := range lines {
-		var fields []string = strings.Split(line, " ")
-		for _, field := range fields {
-			var kv []string = strings.Split(field, "=")
-			if len(kv) != 2 {
-				continue
-			}
-			if kv[0] == "client_database_id" {
-				users[i].TsId = kv[1]
-			}
-			if kv[0] == "client_nickname" {
-				nickname := kv[1]
-				nickname = strings.Replace(nickname, "\\s", " ", -1)
-				users[i].Nickname = nickname
-			}
+	var entries []queryClient
+	if err := decodeQueryResponse(list, &entries); err != nil {
+		return nil, err
+	}
+	users := make([]TeamspeakUser, len(entries))
+	for i, entry := range entries {
+		users[i] = TeamspeakUser{
+			TsId:     entry.DatabaseId,
+			Nickname: entry.Nickname,
+			Clid:     entry.Clid,
+			Cid:      entry.Cid,
+			IsQuery:  entry.Type == 1,
 		}
 	}
 	return users, nil
 }
 
-func getAllTeamspeakUsers(client *ts3.Client) ([]TeamspeakUser, error) {
+// withoutQueryClients drops ServerQuery connections from a user list.
+func withoutQueryClients(users []TeamspeakUser) []TeamspeakUser {
+	var result []TeamspeakUser
+	for _, user := range users {
+		if !user.IsQuery {
+			result = append(result, user)
+		}
+	}
+	return result
+}
+
+func getAllTeamspeakUsers(client TeamspeakClient) ([]TeamspeakUser, error) {
 	list, err := client.Exec("clientdblist")
 	if err != nil {
 		return nil, err
 	}
-	if len(list) == 0 {
-		return nil, nil
-	}
-	var data string = list[0]
-	var lines []string = strings.Split(data, "|")
-	var users []TeamspeakUser = make([]TeamspeakUser, len(lines))
-	for i, line := range lines {
-		var fields []string = strings.Split(line, " ")
-		for _, field := range fields {
-			var kv []string = strings.Split(field, "=")
-			if len(kv) != 2 {
-				continue
-			}
-			if kv[0] == "cldbid" {
-				users[i].TsId = kv[1]
-			}
-			if kv[0] == "client_nickname" {
-				nickname := kv[1]
-				nickname = strings.Replace(nickname, "\\s", " ", -1)
-				users[i].Nickname = nickname
-			}
-		}
+	var entries []queryDatabaseClient
+	if err := decodeQueryResponse(list, &entries); err != nil {
+		return nil, err
+	}
+	users := make([]TeamspeakUser, len(entries))
+	for i, entry := range entries {
+		users[i] = TeamspeakUser{TsId: entry.DatabaseId, Nickname: entry.Nickname}
 	}
 	return users, nil
 }
 
-func setChannelDescription(channel_name string, description string, client *ts3.Client) error {
+func getTeamspeakChannels(client TeamspeakClient) ([]TeamspeakChannel, error) {
 	list, err := client.Exec("channellist")
 	if err != nil {
-		return err
+		return nil, err
 	}
-	log.Println("Setting channel description for", channel_name, "to", description)
-	if len(list) == 0 {
-		return errors.New("No channels found")
-	}
-	var data string = list[0]
-	var lines []string = strings.Split(data, "|")
-	for _, line := range lines {
-		var channel struct {
-			Cid  string
-			Name string
-		}
-		var fields []string = strings.Split(line, " ")
-		for _, field := range fields {
-			var kv []string = strings.Split(field, "=")
-			if len(kv) != 2 {
-				continue
-			}
-			if kv[0] == "cid" {
-				channel.Cid = kv[1]
-			}
-			if kv[0] == "channel_name" {
-				channel.Name = kv[1]
-			}
-		}
+	var channels []TeamspeakChannel
+	if err := decodeQueryResponse(list, &channels); err != nil {
+		return nil, err
+	}
+	if len(channels) == 0 {
+		return nil, errors.New("No channels found")
+	}
+	return channels, nil
+}
+
+func getTeamspeakServerInfo(client TeamspeakClient) (TeamspeakServerInfo, error) {
+	var info TeamspeakServerInfo
+	list, err := client.Exec("serverinfo")
+	if err != nil {
+		return info, err
+	}
+	err = decodeQueryResponse(list, &info)
+	return info, err
+}
+
+func getTeamspeakWhoami(client TeamspeakClient) (TeamspeakWhoami, error) {
+	var whoami TeamspeakWhoami
+	list, err := client.Exec("whoami")
+	if err != nil {
+		return whoami, err
+	}
+	err = decodeQueryResponse(list, &whoami)
+	return whoami, err
+}
+
+func findTeamspeakChannel(channel_name string, client TeamspeakClient) (TeamspeakChannel, error) {
+	channels, err := getTeamspeakChannels(client)
+	if err != nil {
+		return TeamspeakChannel{}, err
+	}
+	for _, channel := range channels {
 		if channel.Name == channel_name {
-			cmd := ts3.NewCmd("channeledit").WithArgs(
-				ts3.NewArg("cid", channel.Cid),
-				ts3.NewArg("channel_description", description),
-			)
-			log.Println("Executing", cmd)
-			_, err := client.ExecCmd(cmd)
-			if err != nil {
-				return err
-			}
-			return nil
+			return channel, nil
 		}
 	}
+	return TeamspeakChannel{}, errors.New("Channel not found")
+}
 
-	return errors.New("Channel not found")
+func getTeamspeakChannelById(cid string, client TeamspeakClient) (TeamspeakChannel, error) {
+	channels, err := getTeamspeakChannels(client)
+	if err != nil {
+		return TeamspeakChannel{}, err
+	}
+	for _, channel := range channels {
+		if channel.Cid == cid {
+			return channel, nil
+		}
+	}
+	return TeamspeakChannel{}, errors.New("Channel not found")
+}
+
+func setChannelDescription(channel_name string, description string, client TeamspeakClient) error {
+	log.Println("Setting channel description for", channel_name, "to", description)
+	channel, err := findTeamspeakChannel(channel_name, client)
+	if err != nil {
+		return err
+	}
+	cmd := ts3.NewCmd("channeledit").WithArgs(
+		ts3.NewArg("cid", channel.Cid),
+		ts3.NewArg("channel_description", description),
+	)
+	log.Println("Executing", cmd)
+	_, err = client.ExecCmd(cmd)
+	return err
 }
 
-func updateTeamspeakQuotes(repository *Repository, teamspeak *ts3.Client) error {
+func updateTeamspeakQuotes(repository Store, teamspeak TeamspeakClient) error {
 	channel_name, ok := repository.GetQuotesChannel()
 	if !ok {
 		return errors.New("No quotes channel configured")
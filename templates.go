@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	template_connect    = "connect"
+	template_disconnect = "disconnect"
+	template_reconnect  = "reconnect"
+
+	default_connect_template    = "Client {name} connected"
+	default_disconnect_template = "Client {name} disconnected {reason}"
+	default_reconnect_template  = "Client {name} reconnected"
+
+	max_template_length = 500
+)
+
+// template_placeholders lists every placeholder a notification template may
+// use, with the description shown by /template.
+var template_placeholders = []struct {
+	Name        string
+	Description string
+}{
+	{"name", "name of the Teamspeak user"},
+	{"channel", "channel the user joined or left from"},
+	{"time", "time of the event"},
+	{"online_count", "number of users online after the event"},
+	{"reason", "disconnect reason in parentheses, empty for a regular disconnect"},
+}
+
+var placeholder_pattern = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// SubscriberEvent is a connect or disconnect that is reported to the
+// subscribers of a Teamspeak user.
+type SubscriberEvent struct {
+	Kind        string
+	TsId        string
+	Nickname    string
+	Cid         string
+	Reason      string
+	OnlineCount int
+	Time        time.Time
+}
+
+// validate_template checks that a template only uses known placeholders and
+// has balanced braces.
+func validate_template(template string) error {
+	if strings.TrimSpace(template) == "" {
+		return fmt.Errorf("template is empty")
+	}
+	if len(template) > max_template_length {
+		return fmt.Errorf("template is longer than %d characters", max_template_length)
+	}
+	for _, match := range placeholder_pattern.FindAllStringSubmatch(template, -1) {
+		if !is_placeholder(match[1]) {
+			return fmt.Errorf("unknown placeholder {%s}", match[1])
+		}
+	}
+	rest := placeholder_pattern.ReplaceAllString(template, "")
+	if strings.ContainsAny(rest, "{}") {
+		return fmt.Errorf("unbalanced braces")
+	}
+	return nil
+}
+
+func is_placeholder(name string) bool {
+	for _, placeholder := range template_placeholders {
+		if placeholder.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// render_template replaces the placeholders of template with values and
+// trims the whitespace left behind by empty ones.
+func render_template(template string, values map[string]string) string {
+	rendered := placeholder_pattern.ReplaceAllStringFunc(template, func(match string) string {
+		return values[match[1:len(match)-1]]
+	})
+	return strings.TrimSpace(rendered)
+}
+
+// template_values returns the placeholder values for event. The channel name
+// has to be resolved by the caller since it needs a ServerQuery round trip.
+func template_values(event SubscriberEvent, name string, channel string) map[string]string {
+	reason := ""
+	if event.Reason != "" {
+		reason = "(" + event.Reason + ")"
+	}
+	return map[string]string{
+		"name":         name,
+		"channel":      channel,
+		"time":         event.Time.Format("15:04"),
+		"online_count": fmt.Sprintf("%d", event.OnlineCount),
+		"reason":       reason,
+	}
+}
+
+// subscriber_template returns the template the subscriber uses for kind.
+// Reconnects, reported by the debouncer, always use the default wording.
+func subscriber_template(settings UserSettings, kind string) string {
+	switch kind {
+	case template_connect:
+		if settings.ConnectTemplate != "" {
+			return settings.ConnectTemplate
+		}
+		return default_connect_template
+	case template_reconnect:
+		return default_reconnect_template
+	}
+	if settings.DisconnectTemplate != "" {
+		return settings.DisconnectTemplate
+	}
+	return default_disconnect_template
+}
+
+// preview_template renders template with sample values.
+func preview_template(template string) string {
+	return render_template(template, map[string]string{
+		"name":         "Anna",
+		"channel":      "Lobby",
+		"time":         time.Now().Format("15:04"),
+		"online_count": "3",
+		"reason":       "(connection lost)",
+	})
+}
@@ -0,0 +1,139 @@
+package ts3fake
+
+import "sort"
+
+func ok(conn *Conn, cmd Command) ([]Record, error) {
+	return nil, nil
+}
+
+func (server *Server) registerDefaultHandlers() {
+	server.handlers["login"] = ok
+	server.handlers["logout"] = ok
+	server.handlers["use"] = ok
+	server.handlers["quit"] = ok
+	server.handlers["version"] = func(conn *Conn, cmd Command) ([]Record, error) {
+		return []Record{{F("version", "3.13.7"), F("build", 1655727713), F("platform", "Linux")}}, nil
+	}
+	server.handlers["serverinfo"] = func(conn *Conn, cmd Command) ([]Record, error) {
+		server.mutex.Lock()
+		defer server.mutex.Unlock()
+		return []Record{{
+			F("virtualserver_name", "Fake Server"),
+			F("virtualserver_port", 9987),
+			F("virtualserver_clientsonline", len(server.clients)+len(server.conns)),
+			F("virtualserver_queryclientsonline", len(server.conns)),
+			F("virtualserver_maxclients", 32),
+			F("virtualserver_uptime", 0),
+		}}, nil
+	}
+	server.handlers["whoami"] = func(conn *Conn, cmd Command) ([]Record, error) {
+		return []Record{{
+			F("virtualserver_status", "online"),
+			F("virtualserver_id", 1),
+			F("virtualserver_port", 9987),
+			F("client_id", conn.ID),
+			F("client_channel_id", conn.ChannelID),
+			F("client_nickname", "serveradmin"),
+			F("client_database_id", 1),
+			F("client_login_name", "serveradmin"),
+		}}, nil
+	}
+	server.handlers["servernotifyregister"] = func(conn *Conn, cmd Command) ([]Record, error) {
+		server.mutex.Lock()
+		defer server.mutex.Unlock()
+		conn.registered[cmd.Args["event"]] = true
+		return nil, nil
+	}
+	server.handlers["servernotifyunregister"] = func(conn *Conn, cmd Command) ([]Record, error) {
+		server.mutex.Lock()
+		defer server.mutex.Unlock()
+		conn.registered = make(map[string]bool)
+		return nil, nil
+	}
+	server.handlers["clientlist"] = func(conn *Conn, cmd Command) ([]Record, error) {
+		server.mutex.Lock()
+		defer server.mutex.Unlock()
+		clients := server.sortedClients()
+		for other := range server.conns {
+			clients = append(clients, Client{ID: other.ID, DatabaseID: 1, ChannelID: other.ChannelID, Nickname: "serveradmin", Type: 1})
+		}
+		sort.Slice(clients, func(i, j int) bool { return clients[i].ID < clients[j].ID })
+		records := make([]Record, len(clients))
+		for i, client := range clients {
+			records[i] = Record{
+				F("clid", client.ID),
+				F("cid", client.ChannelID),
+				F("client_database_id", client.DatabaseID),
+				F("client_nickname", client.Nickname),
+				F("client_type", client.Type),
+			}
+		}
+		return records, nil
+	}
+	server.handlers["clientdblist"] = func(conn *Conn, cmd Command) ([]Record, error) {
+		server.mutex.Lock()
+		defer server.mutex.Unlock()
+		ids := make([]int, 0, len(server.known))
+		for id := range server.known {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+		records := make([]Record, len(ids))
+		for i, id := range ids {
+			records[i] = Record{
+				F("cldbid", id),
+				F("client_nickname", server.known[id].Nickname),
+			}
+		}
+		return records, nil
+	}
+	server.handlers["channellist"] = func(conn *Conn, cmd Command) ([]Record, error) {
+		server.mutex.Lock()
+		defer server.mutex.Unlock()
+		ids := make([]int, 0, len(server.channels))
+		for id := range server.channels {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+		records := make([]Record, len(ids))
+		for i, id := range ids {
+			channel := server.channels[id]
+			total := 0
+			for _, client := range server.clients {
+				if client.ChannelID == id {
+					total++
+				}
+			}
+			records[i] = Record{
+				F("cid", channel.ID),
+				F("pid", channel.ParentID),
+				F("channel_order", 0),
+				F("channel_name", channel.Name),
+				F("total_clients", total),
+			}
+		}
+		return records, nil
+	}
+	server.handlers["clientmove"] = func(conn *Conn, cmd Command) ([]Record, error) {
+		return nil, server.ClientMove(cmd.Int("clid"), cmd.Int("cid"))
+	}
+	server.handlers["sendtextmessage"] = func(conn *Conn, cmd Command) ([]Record, error) {
+		return nil, nil
+	}
+	server.handlers["channeledit"] = func(conn *Conn, cmd Command) ([]Record, error) {
+		server.mutex.Lock()
+		defer server.mutex.Unlock()
+		channel, ok := server.channels[cmd.Int("cid")]
+		if !ok {
+			return nil, ErrInvalidChannel
+		}
+		if name, ok := cmd.Args["channel_name"]; ok {
+			channel.Name = name
+		}
+		if description, ok := cmd.Args["channel_description"]; ok {
+			channel.Description = description
+		}
+		server.channels[channel.ID] = channel
+		return nil, nil
+	}
+}
@@ -0,0 +1,544 @@
+// Package ts3fake implements a scriptable in-process TeamSpeak 3 ServerQuery
+// server. It speaks the same telnet-style protocol as the real server, so the
+// bridge can be pointed at it with an unmodified ts3.Client and exercised
+// end to end without a live TeamSpeak instance.
+package ts3fake
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	header = "TS3"
+	banner = `Welcome to the TeamSpeak 3 ServerQuery interface, type "help" for a list of commands and "help <command>" for information on a specific command.`
+)
+
+// Error is a ServerQuery error returned from a Handler.
+type Error struct {
+	ID      int
+	Message string
+}
+
+func (err Error) Error() string {
+	return fmt.Sprintf("error id=%d msg=%s", err.ID, Escape(err.Message))
+}
+
+var (
+	ErrCommandNotFound = Error{ID: 256, Message: "command not found"}
+	ErrInvalidClientID = Error{ID: 512, Message: "invalid clientID"}
+	ErrInvalidChannel  = Error{ID: 768, Message: "invalid channelID"}
+)
+
+// Command is a single command received from a connected query client.
+type Command struct {
+	Name    string
+	Args    map[string]string
+	Options []string
+}
+
+// Handler answers a command. The returned records are joined with "|" into
+// the single response line the protocol uses; a nil slice sends no data.
+type Handler func(conn *Conn, cmd Command) ([]Record, error)
+
+// Record is one entry of a response or the payload of a notification.
+type Record []Field
+
+// Field is a single key=value pair. Fields with an empty value are sent as a
+// bare key, as the real server does for flags.
+type Field struct {
+	Key   string
+	Value string
+}
+
+// F builds a Field, formatting value with fmt.Sprint.
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: fmt.Sprint(value)}
+}
+
+func (record Record) String() string {
+	parts := make([]string, len(record))
+	for i, field := range record {
+		if field.Value == "" {
+			parts[i] = Escape(field.Key)
+		} else {
+			parts[i] = Escape(field.Key) + "=" + Escape(field.Value)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// Client is a client connected to the fake virtual server.
+type Client struct {
+	ID         int
+	DatabaseID int
+	ChannelID  int
+	Nickname   string
+	// Type is 0 for regular voice clients and 1 for ServerQuery clients.
+	Type int
+}
+
+// Channel is a channel on the fake virtual server.
+type Channel struct {
+	ID          int
+	ParentID    int
+	Name        string
+	Description string
+}
+
+// Server is a fake ServerQuery endpoint listening on a local TCP port.
+type Server struct {
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mutex    sync.Mutex
+	conns    map[*Conn]struct{}
+	handlers map[string]Handler
+	clients  map[int]Client
+	known    map[int]Client
+	channels map[int]Channel
+	received []Command
+	nextID   int
+	closed   bool
+}
+
+// Conn is a single query connection to the fake server.
+type Conn struct {
+	server     *Server
+	conn       net.Conn
+	writeMutex sync.Mutex
+	// ID is the client id the connection has on the virtual server.
+	ID int
+	// ChannelID is the channel the query client is currently in.
+	ChannelID  int
+	registered map[string]bool
+}
+
+// NewServer starts a fake server on a random local port. It comes with a
+// single default channel (cid 1) and handlers for the commands the bridge
+// uses; more can be added or replaced with Handle.
+func NewServer() (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	server := &Server{
+		listener: listener,
+		conns:    make(map[*Conn]struct{}),
+		handlers: make(map[string]Handler),
+		clients:  make(map[int]Client),
+		known:    make(map[int]Client),
+		channels: map[int]Channel{1: {ID: 1, Name: "Default Channel"}},
+		nextID:   1,
+	}
+	server.registerDefaultHandlers()
+	server.wg.Add(1)
+	go server.serve()
+	return server, nil
+}
+
+// Addr returns the host:port the server listens on.
+func (server *Server) Addr() string {
+	return server.listener.Addr().String()
+}
+
+// Port returns the port the server listens on.
+func (server *Server) Port() int {
+	return server.listener.Addr().(*net.TCPAddr).Port
+}
+
+// Close stops the listener and drops all connections.
+func (server *Server) Close() error {
+	server.mutex.Lock()
+	server.closed = true
+	for conn := range server.conns {
+		conn.conn.Close()
+	}
+	server.mutex.Unlock()
+	err := server.listener.Close()
+	server.wg.Wait()
+	return err
+}
+
+// DropConnections closes every open query connection while keeping the
+// listener running, simulating a network failure.
+func (server *Server) DropConnections() {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	for conn := range server.conns {
+		conn.conn.Close()
+	}
+}
+
+// Handle registers (or replaces) the handler for a command.
+func (server *Server) Handle(name string, handler Handler) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.handlers[name] = handler
+}
+
+// Received returns every command received so far, in order.
+func (server *Server) Received() []Command {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return append([]Command{}, server.received...)
+}
+
+// AddChannel adds or replaces a channel.
+func (server *Server) AddChannel(channel Channel) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.channels[channel.ID] = channel
+}
+
+// Channel returns the channel with the given id.
+func (server *Server) Channel(cid int) (Channel, bool) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	channel, ok := server.channels[cid]
+	return channel, ok
+}
+
+// Clients returns the connected clients ordered by client id.
+func (server *Server) Clients() []Client {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return server.sortedClients()
+}
+
+// ClientEnter adds a client to the server and sends notifycliententerview to
+// every connection registered for server or channel events. A zero ID is
+// replaced by the next free client id, which is returned.
+func (server *Server) ClientEnter(client Client) int {
+	server.mutex.Lock()
+	if client.ID == 0 {
+		client.ID = server.allocateID()
+	}
+	if client.ChannelID == 0 {
+		client.ChannelID = 1
+	}
+	server.clients[client.ID] = client
+	if client.Type == 0 {
+		server.known[client.DatabaseID] = client
+	}
+	server.mutex.Unlock()
+
+	server.Notify("cliententerview", Record{
+		F("cfid", 0),
+		F("ctid", client.ChannelID),
+		F("reasonid", 0),
+		F("clid", client.ID),
+		F("client_database_id", client.DatabaseID),
+		F("client_nickname", client.Nickname),
+		F("client_type", client.Type),
+	}, "server", "channel")
+	return client.ID
+}
+
+// ClientLeave removes a client and sends notifyclientleftview. reasonid
+// follows the server's values: 8 for a regular disconnect, 3 for a timeout,
+// 5 for a kick and 6 for a ban.
+func (server *Server) ClientLeave(clid int, reasonid int, reasonmsg string) error {
+	server.mutex.Lock()
+	client, ok := server.clients[clid]
+	if !ok {
+		server.mutex.Unlock()
+		return ErrInvalidClientID
+	}
+	delete(server.clients, clid)
+	server.mutex.Unlock()
+
+	record := Record{
+		F("cfid", client.ChannelID),
+		F("ctid", 0),
+		F("reasonid", reasonid),
+	}
+	if reasonmsg != "" {
+		record = append(record, F("reasonmsg", reasonmsg))
+	}
+	record = append(record, F("clid", clid))
+	server.Notify("clientleftview", record, "server", "channel")
+	return nil
+}
+
+// ClientMove moves a client, or a query connection, into another channel.
+// Moving a regular client sends notifyclientmoved to every connection
+// registered for channel events.
+func (server *Server) ClientMove(clid int, cid int) error {
+	server.mutex.Lock()
+	if _, ok := server.channels[cid]; !ok {
+		server.mutex.Unlock()
+		return ErrInvalidChannel
+	}
+	for conn := range server.conns {
+		if conn.ID == clid {
+			conn.ChannelID = cid
+			server.mutex.Unlock()
+			return nil
+		}
+	}
+	client, ok := server.clients[clid]
+	if !ok {
+		server.mutex.Unlock()
+		return ErrInvalidClientID
+	}
+	client.ChannelID = cid
+	server.clients[clid] = client
+	server.mutex.Unlock()
+
+	server.Notify("clientmoved", Record{
+		F("ctid", cid),
+		F("reasonid", 0),
+		F("clid", clid),
+	}, "channel")
+	return nil
+}
+
+// TextMessage makes a connected client write msg into its current channel.
+// Query connections sitting in that channel and registered for textchannel
+// events receive a notifytextmessage with targetmode=2.
+func (server *Server) TextMessage(clid int, msg string) error {
+	server.mutex.Lock()
+	client, ok := server.clients[clid]
+	var targets []*Conn
+	for conn := range server.conns {
+		if conn.ChannelID == client.ChannelID && conn.registered["textchannel"] {
+			targets = append(targets, conn)
+		}
+	}
+	server.mutex.Unlock()
+	if !ok {
+		return ErrInvalidClientID
+	}
+	line := "notifytextmessage " + Record{
+		F("targetmode", 2),
+		F("msg", msg),
+		F("invokerid", client.ID),
+		F("invokername", client.Nickname),
+	}.String()
+	for _, conn := range targets {
+		conn.WriteLine(line)
+	}
+	return nil
+}
+
+// PrivateMessage makes client clid send msg as a private message to the
+// ServerQuery connections registered for textprivate.
+func (server *Server) PrivateMessage(clid int, msg string) error {
+	server.mutex.Lock()
+	client, ok := server.clients[clid]
+	server.mutex.Unlock()
+	if !ok {
+		return ErrInvalidClientID
+	}
+	server.Notify("textmessage", Record{
+		F("targetmode", 1),
+		F("msg", msg),
+		F("invokerid", client.ID),
+		F("invokername", client.Nickname),
+	}, "textprivate")
+	return nil
+}
+
+// Notify sends "notify<event> <record>" to every connection registered for
+// one of the given event categories.
+func (server *Server) Notify(event string, record Record, categories ...string) {
+	server.mutex.Lock()
+	var targets []*Conn
+	for conn := range server.conns {
+		for _, category := range categories {
+			if conn.registered[category] {
+				targets = append(targets, conn)
+				break
+			}
+		}
+	}
+	server.mutex.Unlock()
+
+	line := "notify" + event + " " + record.String()
+	for _, conn := range targets {
+		conn.WriteLine(line)
+	}
+}
+
+// WriteLine sends a raw protocol line to the connection.
+func (conn *Conn) WriteLine(line string) error {
+	conn.writeMutex.Lock()
+	defer conn.writeMutex.Unlock()
+	_, err := conn.conn.Write([]byte(line + "\n\r"))
+	return err
+}
+
+// Registered reports whether the connection registered for an event category.
+func (conn *Conn) Registered(category string) bool {
+	conn.server.mutex.Lock()
+	defer conn.server.mutex.Unlock()
+	return conn.registered[category]
+}
+
+func (server *Server) serve() {
+	defer server.wg.Done()
+	for {
+		netConn, err := server.listener.Accept()
+		if err != nil {
+			return
+		}
+		server.mutex.Lock()
+		if server.closed {
+			server.mutex.Unlock()
+			netConn.Close()
+			return
+		}
+		conn := &Conn{
+			server:     server,
+			conn:       netConn,
+			ID:         server.allocateID(),
+			ChannelID:  1,
+			registered: make(map[string]bool),
+		}
+		server.conns[conn] = struct{}{}
+		server.mutex.Unlock()
+
+		server.wg.Add(1)
+		go func() {
+			defer server.wg.Done()
+			server.handle(conn)
+		}()
+	}
+}
+
+func (server *Server) handle(conn *Conn) {
+	defer func() {
+		server.mutex.Lock()
+		delete(server.conns, conn)
+		server.mutex.Unlock()
+		conn.conn.Close()
+	}()
+
+	if conn.WriteLine(header) != nil || conn.WriteLine(banner) != nil {
+		return
+	}
+	scanner := bufio.NewScanner(conn.conn)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			// go-ts3 sends a bare space as keepalive, the server ignores it.
+			continue
+		}
+		cmd := ParseCommand(line)
+
+		server.mutex.Lock()
+		server.received = append(server.received, cmd)
+		handler, ok := server.handlers[cmd.Name]
+		server.mutex.Unlock()
+
+		var records []Record
+		err := error(ErrCommandNotFound)
+		if ok {
+			records, err = handler(conn, cmd)
+		}
+		if err == nil && len(records) > 0 {
+			lines := make([]string, len(records))
+			for i, record := range records {
+				lines[i] = record.String()
+			}
+			conn.WriteLine(strings.Join(lines, "|"))
+		}
+		if err != nil {
+			queryErr, ok := err.(Error)
+			if !ok {
+				queryErr = Error{ID: 1, Message: err.Error()}
+			}
+			conn.WriteLine(queryErr.Error())
+		} else {
+			conn.WriteLine("error id=0 msg=ok")
+		}
+		if cmd.Name == "quit" {
+			return
+		}
+	}
+}
+
+// ParseCommand splits a raw command line into its name, arguments and
+// options, undoing the ServerQuery escaping.
+func ParseCommand(line string) Command {
+	tokens := strings.Fields(line)
+	cmd := Command{Args: make(map[string]string)}
+	if len(tokens) == 0 {
+		return cmd
+	}
+	cmd.Name = tokens[0]
+	for _, token := range tokens[1:] {
+		if strings.HasPrefix(token, "-") {
+			cmd.Options = append(cmd.Options, token)
+			continue
+		}
+		key, value, _ := strings.Cut(token, "=")
+		cmd.Args[Unescape(key)] = Unescape(value)
+	}
+	return cmd
+}
+
+// Int returns an integer argument, or 0 if it is missing or malformed.
+func (cmd Command) Int(key string) int {
+	value, _ := strconv.Atoi(cmd.Args[key])
+	return value
+}
+
+func (server *Server) allocateID() int {
+	id := server.nextID
+	server.nextID++
+	return id
+}
+
+func (server *Server) sortedClients() []Client {
+	clients := make([]Client, 0, len(server.clients)+len(server.conns))
+	for _, client := range server.clients {
+		clients = append(clients, client)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ID < clients[j].ID })
+	return clients
+}
+
+var escaper = strings.NewReplacer(
+	`\`, `\\`,
+	`/`, `\/`,
+	` `, `\s`,
+	`|`, `\p`,
+	"\a", `\a`,
+	"\b", `\b`,
+	"\f", `\f`,
+	"\n", `\n`,
+	"\r", `\r`,
+	"\t", `\t`,
+	"\v", `\v`,
+)
+
+var unescaper = strings.NewReplacer(
+	`\\`, `\`,
+	`\/`, `/`,
+	`\s`, ` `,
+	`\p`, `|`,
+	`\a`, "\a",
+	`\b`, "\b",
+	`\f`, "\f",
+	`\n`, "\n",
+	`\r`, "\r",
+	`\t`, "\t",
+	`\v`, "\v",
+)
+
+// Escape applies the ServerQuery escaping to a value.
+func Escape(value string) string {
+	return escaper.Replace(value)
+}
+
+// Unescape reverses Escape.
+func Unescape(value string) string {
+	return unescaper.Replace(value)
+}